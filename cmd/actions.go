@@ -0,0 +1,139 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/penny-vault/eod-maintenance/eod"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var importDryRun bool
+var importOverwrite bool
+var importNoAdjust bool
+var importWorkers int
+
+// importActionsCmd represents the import-actions command
+var importActionsCmd = &cobra.Command{
+	Use:   "import-actions FILE...",
+	Short: "Import dividends and splits from csv files",
+	Long: `Import dividends and splits from csv files.
+
+Each file must have the columns composite_figi, ticker, date, type and
+value. Rows are matched to eod on composite_figi and date, or on ticker
+and date when composite_figi is empty; type is either dividend or split.
+Imported values that differ from an existing dividend or split are
+reported as conflicts and left alone unless --overwrite is given.
+Imported actions are only written to the dividend and split_factor columns
+of eod; the corporate_actions table read by adjust --actions table is not
+changed.
+
+Assets whose corporate actions changed are re-adjusted afterwards.
+Exits with status 2 if any row conflicted or could not be matched.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		delistingMode := delistingFlag(false)
+
+		actions := make([]*eod.CorporateAction, 0)
+		for _, fn := range args {
+			fh, err := os.Open(fn)
+			if err != nil {
+				log.Error().Err(err).Str("FileName", fn).Msg("could not open corporate actions file")
+				os.Exit(1)
+			}
+			fileActions, err := eod.LoadCorporateActions(fh)
+			fh.Close()
+			if err != nil {
+				log.Error().Err(err).Str("FileName", fn).Msg("could not load corporate actions file")
+				os.Exit(1)
+			}
+			actions = append(actions, fileActions...)
+		}
+
+		pool := connectPool(ctx, importWorkers)
+		defer pool.Close()
+
+		summary, err := eod.ImportCorporateActions(ctx, pool, actions, &eod.ImportOptions{
+			DryRun:    importDryRun,
+			Overwrite: importOverwrite,
+		})
+		if err != nil {
+			os.Exit(1)
+		}
+
+		problems := make([]*eod.ActionImportResult, 0)
+		for _, result := range summary.Results {
+			if result.Status == eod.ImportConflict || result.Status == eod.ImportUnmatched || result.Status == eod.ImportAmbiguous {
+				problems = append(problems, result)
+			}
+		}
+		if importDryRun {
+			eod.PrintImportResults(os.Stdout, summary.Results)
+		} else {
+			eod.PrintImportResults(os.Stdout, problems)
+		}
+
+		log.Info().Bool("DryRun", importDryRun).
+			Int("Inserted", summary.Count(eod.ImportInserted)).
+			Int("Updated", summary.Count(eod.ImportUpdated)).
+			Int("Unchanged", summary.Count(eod.ImportUnchanged)).
+			Int("Conflicts", summary.Count(eod.ImportConflict)).
+			Int("Unmatched", summary.Count(eod.ImportUnmatched)+summary.Count(eod.ImportAmbiguous)).
+			Int("AffectedAssets", len(summary.Affected)).
+			Msg("finished importing corporate actions")
+
+		if !importDryRun && !importNoAdjust && len(summary.Affected) > 0 {
+			runID, err := eod.StartRun(ctx, pool, eod.ScopeAssets, time.Time{})
+			if err != nil {
+				os.Exit(1)
+			}
+
+			log.Info().Int("NumAssets", len(summary.Affected)).Msg("re-adjusting assets with imported corporate actions")
+			adjustSummary := eod.AdjustAssets(ctx, pool, summary.Affected, importWorkers, &eod.AdjustOptions{
+				Delisting: delistingMode,
+				Revision:  &eod.Revision{RunID: runID, Reason: "imported corporate actions"},
+			})
+			for figi, err := range adjustSummary.Failed {
+				log.Error().Err(err).Str("CompositeFigi", figi).Msg("failed to adjust asset")
+			}
+
+			if err := eod.FinishRun(ctx, pool, runID, adjustSummary); err != nil {
+				os.Exit(1)
+			}
+			if len(adjustSummary.Failed) > 0 {
+				os.Exit(1)
+			}
+		}
+
+		if len(problems) > 0 {
+			os.Exit(2)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importActionsCmd)
+
+	importActionsCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "report what would change without writing to the database")
+	importActionsCmd.Flags().BoolVar(&importOverwrite, "overwrite", false, "replace stored dividends and splits that conflict with the imported values")
+	importActionsCmd.Flags().BoolVar(&importNoAdjust, "no-adjust", false, "do not re-adjust assets whose corporate actions changed")
+	importActionsCmd.Flags().IntVarP(&importWorkers, "workers", "w", 1, "number of assets to re-adjust concurrently")
+	addDelistingFlag(importActionsCmd)
+}
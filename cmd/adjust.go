@@ -17,16 +17,36 @@ package cmd
 import (
 	"context"
 	"os"
+	"time"
 
-	"github.com/jackc/pgx/v4"
 	"github.com/penny-vault/eod-maintenance/eod"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var recent bool
 var clean bool
+var workers int
+var series []string
+var method string
+var dryRun bool
+var tolerance float64
+var relTolerance float64
+var since string
+var zeroPrice string
+var validate bool
+var actionSource string
+var excludeDividends []string
+var delisting string
+var asOf string
+var output string
+var outputFormat string
+var reason string
+var arithmetic string
+var rounding string
+var roundPlaces int
+var engine string
+var batchSize int
 
 // adjustedCmd represents the adjusted command
 var adjustCmd = &cobra.Command{
@@ -34,88 +54,313 @@ var adjustCmd = &cobra.Command{
 	Short: "Calculate adjusted eod prices",
 	Run: func(cmd *cobra.Command, args []string) {
 		ctx := context.Background()
-		conn, err := pgx.Connect(ctx, viper.GetString("database.url"))
+
+		delistingMode := delistingFlag(output != "")
+
+		adjustEngine, err := eod.ParseEngine(engine)
 		if err != nil {
-			log.Error().Err(err).Msg("could not connect to database")
+			log.Error().Err(err).Msg("invalid --engine value")
 			os.Exit(1)
 		}
-		defer conn.Close(ctx)
 
-		assets := make([]string, 0)
-		if recent {
-			rows, err := conn.Query(ctx, `SELECT DISTINCT composite_figi FROM eod WHERE event_date >= now() - interval '2 days' AND (split_factor != 1.0 OR dividend > 0.0)`)
-			if err != nil {
-				log.Error().Err(err).Msg("could not query database for unique assets")
+		if asOf != "" && output == "" {
+			log.Error().Msg("--as-of requires --output; point-in-time prices are exported instead of saved")
+			os.Exit(1)
+		}
+		if output != "" && outputFormat != "json" && outputFormat != "csv" {
+			log.Error().Str("Format", outputFormat).Msg("--format must be json or csv")
+			os.Exit(1)
+		}
+
+		opts := methodFlags()
+		opts.Delisting = delistingMode
+		opts.DryRun = dryRun
+		opts.Tolerance = eod.Tolerance{Abs: tolerance, Rel: relTolerance}
+		opts.Validate = validate
+		opts.Engine = adjustEngine
+		opts.BatchSize = batchSize
+		if asOf != "" {
+			if opts.AsOf, err = time.Parse("2006-01-02", asOf); err != nil {
+				log.Error().Err(err).Str("AsOf", asOf).Msg("--as-of must be formatted as YYYY-MM-DD")
+				os.Exit(1)
 			}
-			for rows.Next() {
-				var figi string
-				if err := rows.Scan(&figi); err != nil {
-					log.Error().Err(err).Msg("could not scan composite_figi into variable")
-					os.Exit(1)
-				}
-				assets = append(assets, figi)
+		}
+		for _, name := range series {
+			s, err := eod.ParseSeries(name)
+			if err != nil {
+				log.Error().Err(err).Msg("invalid --series value")
+				os.Exit(1)
 			}
+			opts.Series = append(opts.Series, s)
+		}
+		if err := eod.CheckSeries(opts); err != nil {
+			log.Error().Err(err).Str("Method", method).Msg("--series split and net require --method crsp")
+			os.Exit(1)
 		}
 
-		if clean {
-			rows, err := conn.Query(ctx, `SELECT DISTINCT composite_figi FROM eod WHERE adj_close is null;`)
-			if err != nil {
-				log.Error().Err(err).Msg("could not query database for unique assets")
+		if adjustEngine == eod.SQLEngine {
+			if output != "" {
+				log.Error().Msg("--engine sql cannot be combined with --output")
+				os.Exit(1)
 			}
-			for rows.Next() {
-				var figi string
-				if err := rows.Scan(&figi); err != nil {
-					log.Error().Err(err).Msg("could not scan composite_figi into variable")
-					os.Exit(1)
-				}
-				assets = append(assets, figi)
+			if err := eod.CheckSQLEngine(opts); err != nil {
+				log.Error().Err(err).Msg("--engine sql only supports the default adjustment options")
+				os.Exit(1)
 			}
 		}
 
-		if !recent && !clean && len(args) == 0 {
-			rows, err := conn.Query(ctx, `SELECT DISTINCT composite_figi FROM assets`)
+		pool := connectPool(ctx, workers)
+		defer pool.Close()
+
+		scope := eod.ScopeAll
+		var watermark time.Time
+		assets := make([]string, 0)
+		if recent || since != "" {
+			scope, watermark = recentWatermark(ctx, pool)
+			recentAssets, err := eod.AssetsWithActionsSince(ctx, pool, watermark)
 			if err != nil {
-				log.Error().Err(err).Msg("could not query database for unique assets")
-			}
-			for rows.Next() {
-				var figi string
-				if err := rows.Scan(&figi); err != nil {
-					log.Error().Err(err).Msg("could not scan composite_figi into variable")
-					os.Exit(1)
-				}
-				assets = append(assets, figi)
+				os.Exit(1)
 			}
+			assets = append(assets, recentAssets...)
 		}
 
-		// convert input arguments to figi's
-		for _, inp := range args {
-			var figi string
-			if err := conn.QueryRow(ctx, `SELECT composite_figi FROM assets WHERE ticker = $1 OR composite_figi = $1 LIMIT 1`, inp).Scan(&figi); err != nil {
-				log.Error().Err(err).Str("InputArg", inp).Msg("could not convert input argument to composite figi")
-				continue
+		if clean {
+			if scope == eod.ScopeAll {
+				scope = eod.ScopeClean
 			}
-			assets = append(assets, figi)
+			assets = append(assets, queryAssets(ctx, pool, `SELECT DISTINCT composite_figi FROM eod WHERE adj_close is null;`)...)
 		}
 
-		log.Info().Int("NumAssets", len(assets)).Msg("adjusting close prices")
-		for _, asset := range assets {
-			log.Info().Str("CompositeFigi", asset).Msg("adjusting close price for asset")
-			prices, err := eod.AdjustAssetEodPrice(ctx, conn, asset)
-			if err != nil {
-				log.Error().Err(err).Str("CompositeFigi", asset).Msg("could not adjust asset prices")
-				continue
+		if len(args) > 0 && scope == eod.ScopeAll {
+			scope = eod.ScopeAssets
+		}
+
+		if scope == eod.ScopeAll {
+			assets = append(assets, queryAssets(ctx, pool, `SELECT DISTINCT composite_figi FROM assets`)...)
+		}
+
+		assets = append(assets, resolveAssets(ctx, pool, args)...)
+		assets = uniqueAssets(assets)
+
+		if output != "" {
+			exportAdjustedPrices(ctx, pool, assets, opts)
+			return
+		}
+
+		var runID int64
+		if !dryRun {
+			if runID, err = eod.StartRun(ctx, pool, scope, watermark); err != nil {
+				os.Exit(1)
 			}
-			if err := eod.SaveAdjCloseToDb(ctx, conn, prices); err != nil {
-				log.Error().Err(err).Str("CompositeFigi", asset).Msg("could not save adjusted close to db")
-				continue
+			opts.Revision = &eod.Revision{RunID: runID, Reason: reason}
+			if opts.Revision.Reason == "" {
+				opts.Revision.Reason = "adjust " + scope
 			}
 		}
+
+		log.Info().Int("NumAssets", len(assets)).Int("Workers", workers).Msg("adjusting close prices")
+		summary := eod.AdjustAssets(ctx, pool, assets, workers, opts)
+
+		for figi, err := range summary.Failed {
+			log.Error().Err(err).Str("CompositeFigi", figi).Msg("failed to adjust asset")
+		}
+		log.Info().Int("Succeeded", len(summary.Succeeded)).Int("Failed", len(summary.Failed)).Msg("finished adjusting close prices")
+
+		if dryRun {
+			eod.PrintAdjCloseDiffs(os.Stdout, summary.Diffs)
+			return
+		}
+
+		if err := eod.FinishRun(ctx, pool, runID, summary); err != nil {
+			os.Exit(1)
+		}
 	},
 }
 
+// exportAdjustedPrices calculates adjusted prices for assets and writes them
+// to the --output file without changing the database
+func exportAdjustedPrices(ctx context.Context, conn eod.PgxIface, assets []string, opts *eod.AdjustOptions) {
+	// dry run keeps quarantined quotes from being recorded; every series is
+	// calculated since the export has a column for each of them
+	exportOpts := *opts
+	exportOpts.DryRun = true
+	exportOpts.Series = eod.AllSeries
+
+	log.Info().Int("NumAssets", len(assets)).Time("AsOf", opts.AsOf).Str("FileName", output).Msg("exporting adjusted prices")
+
+	prices := make([]*eod.Eod, 0)
+	numFailed := 0
+	for _, compositeFigi := range assets {
+		assetPrices, err := eod.AdjustAssetEodPriceWithOptions(ctx, conn, compositeFigi, &exportOpts)
+		if err != nil {
+			log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("failed to adjust asset")
+			numFailed++
+			continue
+		}
+		prices = append(prices, assetPrices...)
+	}
+
+	fh, err := os.Create(output)
+	if err != nil {
+		log.Error().Err(err).Str("FileName", output).Msg("could not create output file")
+		os.Exit(1)
+	}
+	if err := eod.WriteAdjustedPrices(fh, outputFormat, prices); err != nil {
+		log.Error().Err(err).Str("FileName", output).Msg("could not write adjusted prices")
+		fh.Close()
+		os.Exit(1)
+	}
+	fh.Close()
+
+	log.Info().Int("Rows", len(prices)).Int("Failed", numFailed).Msg("finished exporting adjusted prices")
+	if numFailed > 0 {
+		os.Exit(1)
+	}
+}
+
+// addDelistingFlag registers --delisting on cmd. adjust, verify and
+// import-actions share the flag and its default so they calculate the same
+// adjusted history.
+func addDelistingFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&delisting, "delisting", string(eod.DelistingIgnore), "how to add delisting returns: ignore, fold (into the final adj_close) or row (synthetic final quote; adjust --output only)")
+}
+
+// delistingFlag returns the mode selected by --delisting. Synthetic rows are
+// never saved, so row is only accepted when the prices are exported.
+func delistingFlag(export bool) eod.DelistingMode {
+	mode, err := eod.ParseDelistingMode(delisting)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid --delisting value")
+		os.Exit(1)
+	}
+	if mode == eod.DelistingRow && !export {
+		log.Error().Msg("--delisting row requires adjust --output; synthetic rows are not saved")
+		os.Exit(1)
+	}
+	return mode
+}
+
+// addMethodFlags registers the flags selecting how adjusted prices are
+// calculated on cmd. adjust and verify share them so verify recalculates
+// prices the way they were saved.
+func addMethodFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&method, "method", "crsp", "adjustment method: crsp, additive, forward, split")
+	cmd.Flags().StringVar(&actionSource, "actions", string(eod.EodActions), "where to read dividends and splits from: eod (columns on eod) or table (corporate_actions)")
+	cmd.Flags().StringSliceVar(&excludeDividends, "exclude-dividends", nil, "dividend types to leave out of the adjusted series: regular, special, capital_gains, return_of_capital")
+	cmd.Flags().StringVar(&arithmetic, "arithmetic", string(eod.FloatArithmetic), "how cumulative factors are multiplied: float (float64) or big (arbitrary precision, slower)")
+	cmd.Flags().StringVar(&rounding, "round", string(eod.RoundNone), "how adjusted prices are rounded before they are saved: none, half-even, half-up, truncate")
+	cmd.Flags().IntVar(&roundPlaces, "round-places", 6, "decimal places adjusted prices are rounded to with --round")
+	cmd.Flags().StringVar(&zeroPrice, "zero-price", string(eod.CarryFactor), "how to treat zero or missing close prices: carry, skip, abort (--engine sql only supports carry)")
+}
+
+// methodFlags returns options with the methodology selected by the flags of
+// addMethodFlags
+func methodFlags() *eod.AdjustOptions {
+	adjuster, err := eod.ParseAdjuster(method)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid --method value")
+		os.Exit(1)
+	}
+
+	policy, err := eod.ParseZeroPricePolicy(zeroPrice)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid --zero-price value")
+		os.Exit(1)
+	}
+
+	source, err := eod.ParseActionSource(actionSource)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid --actions value")
+		os.Exit(1)
+	}
+
+	arith, err := eod.ParseArithmetic(arithmetic)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid --arithmetic value")
+		os.Exit(1)
+	}
+
+	opts := &eod.AdjustOptions{
+		Adjuster:        adjuster,
+		ActionSource:    source,
+		ZeroPricePolicy: policy,
+		Arithmetic:      arith,
+		Rounding:        roundingPolicy(),
+	}
+	for _, name := range excludeDividends {
+		divType, err := eod.ParseDividendType(name)
+		if err != nil {
+			log.Error().Err(err).Msg("invalid --exclude-dividends value")
+			os.Exit(1)
+		}
+		opts.ExcludedDividends = append(opts.ExcludedDividends, divType)
+	}
+
+	return opts
+}
+
+// roundingPolicy returns the policy selected by --round and --round-places
+func roundingPolicy() eod.RoundingPolicy {
+	mode, err := eod.ParseRoundingMode(rounding)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid --round value")
+		os.Exit(1)
+	}
+	if roundPlaces < 0 {
+		log.Error().Int("Places", roundPlaces).Msg("--round-places must not be negative")
+		os.Exit(1)
+	}
+	return eod.RoundingPolicy{Mode: mode, Places: roundPlaces}
+}
+
+// recentWatermark returns the time corporate actions must have changed after
+// to be included in a recent run and the scope the run should be recorded
+// with. An explicit --since only advances the watermark when it does not
+// skip past the previous one.
+func recentWatermark(ctx context.Context, conn eod.PgxIface) (string, time.Time) {
+	watermark, ok, err := eod.LoadWatermark(ctx, conn)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	if since != "" {
+		sinceDate, err := time.Parse("2006-01-02", since)
+		if err != nil {
+			log.Error().Err(err).Str("Since", since).Msg("--since must be formatted as YYYY-MM-DD")
+			os.Exit(1)
+		}
+		if ok && sinceDate.After(watermark) {
+			return eod.ScopeSince, sinceDate
+		}
+		return eod.ScopeRecent, sinceDate
+	}
+
+	if !ok {
+		watermark = time.Now().Add(-48 * time.Hour)
+		log.Warn().Time("Since", watermark).Msg("no successful adjust run recorded; using the last 2 days")
+	}
+
+	return eod.ScopeRecent, watermark
+}
+
 func init() {
 	rootCmd.AddCommand(adjustCmd)
 
-	adjustCmd.Flags().BoolVarP(&recent, "recent", "r", false, "calculated adjusted price for recently changed eod tickers")
+	adjustCmd.Flags().BoolVarP(&recent, "recent", "r", false, "calculate adjusted price for assets with corporate actions changed since the last successful run")
+	adjustCmd.Flags().StringVar(&since, "since", "", "calculate adjusted price for assets with corporate actions changed since DATE (YYYY-MM-DD); overrides the stored watermark")
 	adjustCmd.Flags().BoolVarP(&clean, "clean", "c", false, "clean assets that have null values in adj_close")
+	adjustCmd.Flags().IntVarP(&workers, "workers", "w", 1, "number of assets to adjust concurrently")
+	adjustCmd.Flags().BoolVar(&dryRun, "dry-run", false, "compare the calculated --series with the stored values without writing to the database")
+	adjustCmd.Flags().Float64Var(&tolerance, "tolerance", 0, "ignore absolute differences up to this value in --dry-run")
+	adjustCmd.Flags().Float64Var(&relTolerance, "rel-tolerance", 0, "ignore differences up to this fraction of the stored value in --dry-run (e.g. 1e-9)")
+	addMethodFlags(adjustCmd)
+	addDelistingFlag(adjustCmd)
+	adjustCmd.Flags().StringVar(&asOf, "as-of", "", "calculate prices as they would have appeared on DATE (YYYY-MM-DD) using only quotes and actions at or before it; requires --output")
+	adjustCmd.Flags().StringVarP(&output, "output", "o", "", "write adjusted prices to this file instead of saving them to the database")
+	adjustCmd.Flags().StringVar(&outputFormat, "format", "csv", "--output format: json or csv")
+	adjustCmd.Flags().StringVar(&reason, "reason", "", "note appended to the reason derived for each asset from its changed corporate actions in eod_adj_close_history; defaults to \"adjust SCOPE\"")
+	adjustCmd.Flags().StringVar(&engine, "engine", string(eod.GoEngine), "where prices are calculated: go or sql (window functions in PostgreSQL, batches of --batch-size assets; check the result with verify)")
+	adjustCmd.Flags().IntVar(&batchSize, "batch-size", eod.DefaultSQLBatchSize, "number of assets adjusted per statement by --engine sql")
+	adjustCmd.Flags().BoolVar(&validate, "validate", false, "refuse to adjust assets that fail hard data quality checks (see validate)")
+	adjustCmd.Flags().StringSliceVar(&series, "series", []string{string(eod.TotalReturnSeries)}, "adjusted series to write: total (adj_close), split (split_adj_close), net (net_adj_close, dividends net of withholding tax); split and net are always CRSP adjusted and require --method crsp")
 }
@@ -0,0 +1,95 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/penny-vault/eod-maintenance/eod"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// connectPool opens a connection pool sized for the number of workers
+func connectPool(ctx context.Context, workers int) *pgxpool.Pool {
+	config, err := pgxpool.ParseConfig(viper.GetString("database.url"))
+	if err != nil {
+		log.Error().Err(err).Msg("could not parse database url")
+		os.Exit(1)
+	}
+	if workers > 0 {
+		config.MaxConns = int32(workers)
+	}
+
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		log.Error().Err(err).Msg("could not connect to database")
+		os.Exit(1)
+	}
+
+	return pool
+}
+
+// queryAssets returns the list of composite figi's returned by sql
+func queryAssets(ctx context.Context, conn eod.PgxIface, sql string, args ...interface{}) []string {
+	assets := make([]string, 0)
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("could not query database for unique assets")
+		os.Exit(1)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var figi string
+		if err := rows.Scan(&figi); err != nil {
+			log.Error().Err(err).Msg("could not scan composite_figi into variable")
+			os.Exit(1)
+		}
+		assets = append(assets, figi)
+	}
+
+	return assets
+}
+
+// resolveAssets converts input arguments (tickers or composite figi's) to
+// composite figi's
+func resolveAssets(ctx context.Context, conn eod.PgxIface, args []string) []string {
+	assets := make([]string, 0, len(args))
+	for _, inp := range args {
+		var figi string
+		if err := conn.QueryRow(ctx, `SELECT composite_figi FROM assets WHERE ticker = $1 OR composite_figi = $1 LIMIT 1`, inp).Scan(&figi); err != nil {
+			log.Error().Err(err).Str("InputArg", inp).Msg("could not convert input argument to composite figi")
+			continue
+		}
+		assets = append(assets, figi)
+	}
+	return assets
+}
+
+// uniqueAssets removes duplicate composite figi's while preserving order
+func uniqueAssets(assets []string) []string {
+	seen := make(map[string]bool, len(assets))
+	unique := make([]string, 0, len(assets))
+	for _, figi := range assets {
+		if !seen[figi] {
+			seen[figi] = true
+			unique = append(unique, figi)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,64 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/penny-vault/eod-maintenance/eod"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// rollbackCmd represents the adjust rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback RUN_ID",
+	Short: "Restore the adjusted prices overwritten by an adjust run",
+	Long: `Restore the adjusted prices overwritten by an adjust run.
+
+Every adjusted series value and adjustment factor changed by the run is set
+back to the value it replaced, and factor rows the run removed are put back,
+in a single transaction. The rollback is refused if any of those values no
+longer hold what the run wrote or the quotes have been revised since the run
+started by a later run or a save outside a run; roll back the later runs
+first.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		runID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			log.Error().Err(err).Str("RunID", args[0]).Msg("run id must be an integer")
+			os.Exit(1)
+		}
+
+		pool := connectPool(ctx, 1)
+		defer pool.Close()
+
+		restored, err := eod.RollbackRun(ctx, pool, runID)
+		if err != nil {
+			log.Error().Err(err).Int64("RunID", runID).Msg("could not roll back adjust run")
+			os.Exit(1)
+		}
+
+		log.Info().Int64("RunID", runID).Int64("Restored", restored).Msg("rolled back adjust run")
+	},
+}
+
+func init() {
+	adjustCmd.AddCommand(rollbackCmd)
+}
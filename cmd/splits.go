@@ -0,0 +1,86 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/penny-vault/eod-maintenance/eod"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var splitTolerance float64
+var applySplits bool
+var splitConfirmDays int
+
+// detectSplitsCmd represents the detect-splits command
+var detectSplitsCmd = &cobra.Command{
+	Use:   "detect-splits [ticker or figi...]",
+	Short: "Infer missing splits from unexplained price jumps",
+	Long: `Infer missing splits from unexplained price jumps.
+
+Day-over-day close ratios within --tolerance of a common split ratio on
+days without a recorded split are proposed as splits; the dividend going
+ex on the day is taken off the previous close first. A proposal is
+confirmed when the price stays at the new level for --confirm-days
+quotes. Every proposal is recorded once in split_inference_audit; the
+split factor in eod is only changed for confirmed proposals when
+--apply is given. Run adjust --recent afterwards to recalculate the
+affected assets.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		eod.SplitConfirmationDays = splitConfirmDays
+
+		pool := connectPool(ctx, 1)
+		defer pool.Close()
+
+		var assets []string
+		if len(args) == 0 {
+			assets = queryAssets(ctx, pool, `SELECT DISTINCT composite_figi FROM assets`)
+		} else {
+			assets = resolveAssets(ctx, pool, args)
+		}
+
+		log.Info().Int("NumAssets", len(assets)).Bool("Apply", applySplits).Msg("detecting missing splits")
+
+		proposals := make([]*eod.SplitProposal, 0)
+		for _, compositeFigi := range assets {
+			assetProposals, err := eod.DetectSplits(ctx, pool, compositeFigi, splitTolerance)
+			if err != nil {
+				os.Exit(1)
+			}
+			if len(assetProposals) == 0 {
+				continue
+			}
+			if err := eod.SaveSplitProposals(ctx, pool, assetProposals, applySplits); err != nil {
+				os.Exit(1)
+			}
+			proposals = append(proposals, assetProposals...)
+		}
+
+		eod.PrintSplitProposals(os.Stdout, proposals)
+		log.Info().Int("Proposals", len(proposals)).Bool("Applied", applySplits).Msg("finished detecting missing splits")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(detectSplitsCmd)
+
+	detectSplitsCmd.Flags().Float64Var(&splitTolerance, "tolerance", eod.DefaultSplitTolerance, "maximum relative difference between a price ratio and a split ratio")
+	detectSplitsCmd.Flags().IntVar(&splitConfirmDays, "confirm-days", eod.SplitConfirmationDays, "number of quotes the price must stay at the new level to confirm a split")
+	detectSplitsCmd.Flags().BoolVar(&applySplits, "apply", false, "write confirmed split factors to eod")
+}
@@ -46,6 +46,13 @@ var syntheticCmd = &cobra.Command{
 		}
 		defer conn.Close(ctx)
 
+		arith, err := eod.ParseArithmetic(arithmetic)
+		if err != nil {
+			log.Error().Err(err).Msg("invalid --arithmetic value")
+			os.Exit(1)
+		}
+		policy := roundingPolicy()
+
 		assets := make(map[string]*eod.SyntheticAsset)
 
 		abspath, err := filepath.Abs(args[0])
@@ -74,11 +81,17 @@ var syntheticCmd = &cobra.Command{
 			// load recent eod quotes for asset
 			history := eod.LoadEodHistory(ctx, conn, asset)
 			log.Info().Str("Asset.Symbol", asset.Symbol).Str("Asset.Name", asset.Name).Msg("building synthetic history for specified asset")
-			quotes, err := eod.BuildSyntheticHistory(ctx, asset, history)
+			quotes, err := eod.BuildSyntheticHistoryWithArithmetic(ctx, asset, history, arith)
 			if err != nil {
 				continue
 			}
 
+			// synthetic quotes have no raw close; both are calculated
+			for _, quote := range quotes {
+				quote.Close = policy.Round(quote.Close)
+				quote.AdjClose = policy.Round(quote.AdjClose)
+			}
+
 			if printToScreen {
 				eod.PrintEod(quotes)
 			}
@@ -97,4 +110,7 @@ func init() {
 
 	syntheticCmd.Flags().BoolVarP(&printToScreen, "print", "p", false, "Print EOD quotes to the screen")
 	syntheticCmd.Flags().BoolVarP(&saveDB, "save", "s", false, "Save EOD quotes to the database")
+	syntheticCmd.Flags().StringVar(&arithmetic, "arithmetic", string(eod.FloatArithmetic), "how percent changes are compounded: float (float64) or big (arbitrary precision)")
+	syntheticCmd.Flags().StringVar(&rounding, "round", string(eod.RoundNone), "how prices are rounded before they are saved: none, half-even, half-up, truncate")
+	syntheticCmd.Flags().IntVar(&roundPlaces, "round-places", 6, "decimal places prices are rounded to with --round")
 }
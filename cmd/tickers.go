@@ -0,0 +1,53 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/penny-vault/eod-maintenance/eod"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// tickerChangesCmd represents the ticker-changes command
+var tickerChangesCmd = &cobra.Command{
+	Use:   "ticker-changes [ticker or figi...]",
+	Short: "Report composite figi's that have traded under multiple tickers",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		pool := connectPool(ctx, 1)
+		defer pool.Close()
+
+		assets := resolveAssets(ctx, pool, args)
+		if len(args) > 0 && len(assets) == 0 {
+			log.Error().Strs("Args", args).Msg("none of the requested assets were found")
+			os.Exit(1)
+		}
+
+		changes, err := eod.TickerChanges(ctx, pool, assets...)
+		if err != nil {
+			os.Exit(1)
+		}
+
+		eod.PrintTickerChanges(os.Stdout, changes)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tickerChangesCmd)
+}
@@ -0,0 +1,120 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/penny-vault/eod-maintenance/eod"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var validateMaxSplitFactor float64
+var validateMaxDailyMove float64
+var validateReport string
+var validateFormat string
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate [ticker or figi...]",
+	Short: "Check eod quotes for data quality problems",
+	Long: `Check eod quotes for data quality problems.
+
+Hard checks flag non-positive or missing prices, implausible split
+factors, dividends larger than the close and duplicate dates of one
+ticker. Soft checks flag day-over-day moves larger than --max-move on
+days without a split or dividend and dates quoted under more than one
+ticker of the same figi.
+
+Exits with status 2 if any asset fails a hard check and with status 1
+if an asset could not be validated.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		if validateFormat != "json" && validateFormat != "csv" {
+			log.Error().Str("Format", validateFormat).Msg("--format must be json or csv")
+			os.Exit(1)
+		}
+
+		rules := &eod.ValidationRules{
+			MaxSplitFactor: validateMaxSplitFactor,
+			MaxDailyMove:   validateMaxDailyMove,
+		}
+
+		pool := connectPool(ctx, 1)
+		defer pool.Close()
+
+		var assets []string
+		if len(args) == 0 {
+			assets = queryAssets(ctx, pool, `SELECT DISTINCT composite_figi FROM assets`)
+		} else {
+			assets = resolveAssets(ctx, pool, args)
+		}
+
+		log.Info().Int("NumAssets", len(assets)).Msg("validating eod quotes")
+
+		issues := make([]*eod.ValidationIssue, 0)
+		failedAssets := make(map[string]bool)
+		numErrors := 0
+		for _, compositeFigi := range assets {
+			assetIssues, err := eod.ValidateAsset(ctx, pool, compositeFigi, rules)
+			if err != nil {
+				numErrors++
+				continue
+			}
+			if eod.HasHardIssues(assetIssues) {
+				failedAssets[compositeFigi] = true
+			}
+			issues = append(issues, assetIssues...)
+		}
+
+		eod.PrintValidationIssues(os.Stdout, issues)
+
+		if validateReport != "" {
+			fh, err := os.Create(validateReport)
+			if err != nil {
+				log.Error().Err(err).Str("FileName", validateReport).Msg("could not create report file")
+				os.Exit(1)
+			}
+			if err := eod.WriteValidationReport(fh, validateFormat, issues); err != nil {
+				log.Error().Err(err).Str("FileName", validateReport).Msg("could not write report")
+				fh.Close()
+				os.Exit(1)
+			}
+			fh.Close()
+		}
+
+		log.Info().Int("Validated", len(assets)-numErrors).Int("Issues", len(issues)).Int("FailedHardChecks", len(failedAssets)).Int("Errors", numErrors).Msg("finished validating eod quotes")
+
+		if len(failedAssets) > 0 {
+			os.Exit(2)
+		}
+		if numErrors > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	defaults := eod.DefaultValidationRules()
+	validateCmd.Flags().Float64Var(&validateMaxSplitFactor, "max-split-factor", defaults.MaxSplitFactor, "split factors above this value (or below its inverse) fail validation")
+	validateCmd.Flags().Float64Var(&validateMaxDailyMove, "max-move", defaults.MaxDailyMove, "flag day-over-day close changes larger than this fraction without a corporate action")
+	validateCmd.Flags().StringVarP(&validateReport, "report", "o", "", "write issues to this file")
+	validateCmd.Flags().StringVar(&validateFormat, "format", "json", "report format: json or csv")
+}
@@ -0,0 +1,116 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/penny-vault/eod-maintenance/eod"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var verifyTolerance float64
+var verifyRelTolerance float64
+var verifyWorkers int
+var verifyReport string
+var verifyFormat string
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify [ticker or figi...]",
+	Short: "Audit stored adjusted prices against a fresh calculation",
+	Long: `Audit stored adjusted prices against a fresh calculation.
+
+Exits with status 2 if any of an asset's stored total return prices
+(adj_close, adj_open, adj_high, adj_low and adj_volume) differ from the
+calculated values by more than the tolerance and with status 1 if an
+asset could not be verified. Prices are recalculated with the methodology
+flags of adjust, which must match the ones the prices were saved with.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		if verifyFormat != "json" && verifyFormat != "csv" {
+			log.Error().Str("Format", verifyFormat).Msg("--format must be json or csv")
+			os.Exit(1)
+		}
+		opts := methodFlags()
+		opts.Delisting = delistingFlag(false)
+		opts.DryRun = true
+		opts.Tolerance = eod.Tolerance{Abs: verifyTolerance, Rel: verifyRelTolerance}
+
+		pool := connectPool(ctx, verifyWorkers)
+		defer pool.Close()
+
+		var assets []string
+		if len(args) == 0 {
+			assets = queryAssets(ctx, pool, `SELECT DISTINCT composite_figi FROM assets`)
+		} else {
+			assets = resolveAssets(ctx, pool, args)
+		}
+
+		log.Info().Int("NumAssets", len(assets)).Float64("Tolerance", verifyTolerance).Float64("RelTolerance", verifyRelTolerance).Msg("verifying adjusted close prices")
+		summary := eod.AdjustAssets(ctx, pool, assets, verifyWorkers, opts)
+
+		drifted := make([]*eod.AdjCloseDiff, 0)
+		for _, diff := range summary.Diffs {
+			if diff.Changed() {
+				drifted = append(drifted, diff)
+			}
+		}
+
+		for figi, err := range summary.Failed {
+			log.Error().Err(err).Str("CompositeFigi", figi).Msg("failed to verify asset")
+		}
+
+		eod.PrintAdjCloseDiffs(os.Stdout, drifted)
+
+		if verifyReport != "" {
+			fh, err := os.Create(verifyReport)
+			if err != nil {
+				log.Error().Err(err).Str("FileName", verifyReport).Msg("could not create report file")
+				os.Exit(1)
+			}
+			if err := eod.WriteDriftReport(fh, verifyFormat, drifted); err != nil {
+				log.Error().Err(err).Str("FileName", verifyReport).Msg("could not write report")
+				fh.Close()
+				os.Exit(1)
+			}
+			fh.Close()
+		}
+
+		log.Info().Int("Verified", len(summary.Succeeded)).Int("Drifted", len(drifted)).Int("Failed", len(summary.Failed)).Msg("finished verifying adjusted close prices")
+
+		if len(drifted) > 0 {
+			os.Exit(2)
+		}
+		if len(summary.Failed) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().Float64Var(&verifyTolerance, "tolerance", 1e-6, "maximum absolute difference between stored and calculated values")
+	verifyCmd.Flags().Float64Var(&verifyRelTolerance, "rel-tolerance", 0, "maximum difference between stored and calculated values as a fraction of the stored value")
+	verifyCmd.Flags().IntVarP(&verifyWorkers, "workers", "w", 1, "number of assets to verify concurrently")
+	verifyCmd.Flags().StringVarP(&verifyReport, "report", "o", "", "write offending assets and dates to this file")
+	verifyCmd.Flags().StringVar(&verifyFormat, "format", "json", "report format: json or csv")
+	addMethodFlags(verifyCmd)
+	addDelistingFlag(verifyCmd)
+}
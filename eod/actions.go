@@ -0,0 +1,271 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gocarina/gocsv"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrUnknownActionType  = errors.New("unknown corporate action type")
+	ErrInvalidActionInput = errors.New("corporate action must have a date and a composite figi or ticker")
+)
+
+// Corporate action types. Only dividends and splits can be imported into
+// eod; the other types are only stored in corporate_actions.
+const (
+	ActionDividend      = "dividend"
+	ActionSplit         = "split"
+	ActionSpinOff       = "spinoff"
+	ActionStockDividend = "stock_dividend"
+	ActionRights        = "rights"
+)
+
+// Outcomes of importing a corporate action
+const (
+	ImportInserted  = "inserted"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+	ImportConflict  = "conflict"
+	ImportUnmatched = "unmatched"
+	ImportAmbiguous = "ambiguous"
+)
+
+// CorporateAction is a dividend or split read from an import file. Rows are
+// matched to eod on CompositeFigi when it is set and on Ticker otherwise.
+type CorporateAction struct {
+	CompositeFigi string    `csv:"composite_figi"`
+	Ticker        string    `csv:"ticker"`
+	EventDateStr  string    `csv:"date"`
+	EventDate     time.Time `csv:"-"`
+	Type          string    `csv:"type"`
+	Value         float64   `csv:"value"`
+}
+
+// ImportOptions controls ImportCorporateActions
+type ImportOptions struct {
+	// DryRun reports what would change without writing to the database
+	DryRun bool
+
+	// Overwrite replaces values that conflict with the stored ones; without
+	// it conflicts are only reported
+	Overwrite bool
+}
+
+// ActionImportResult is the outcome of importing a single corporate action
+type ActionImportResult struct {
+	Action        *CorporateAction
+	CompositeFigi string
+	Status        string
+	Stored        float64
+}
+
+// ImportSummary lists the outcome of every action in an import and the assets
+// whose corporate actions changed (or would change in a dry run)
+type ImportSummary struct {
+	Results  []*ActionImportResult
+	Affected []string
+}
+
+// Count returns the number of results with status
+func (summary *ImportSummary) Count(status string) int {
+	cnt := 0
+	for _, result := range summary.Results {
+		if result.Status == status {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// LoadCorporateActions reads corporate actions from a csv with the columns
+// composite_figi, ticker, date (YYYY-MM-DD), type (dividend or split) and value
+func LoadCorporateActions(r io.Reader) ([]*CorporateAction, error) {
+	actions := []*CorporateAction{}
+	if err := gocsv.Unmarshal(r, &actions); err != nil {
+		log.Error().Err(err).Msg("could not read corporate actions")
+		return nil, err
+	}
+
+	for idx, action := range actions {
+		var err error
+		if action.EventDate, err = time.Parse("2006-01-02", action.EventDateStr); err != nil {
+			log.Error().Err(err).Int("Row", idx+1).Str("DateString", action.EventDateStr).Msg("could not parse event date")
+			return nil, err
+		}
+		if action.CompositeFigi == "" && action.Ticker == "" {
+			return nil, fmt.Errorf("%w: row %d", ErrInvalidActionInput, idx+1)
+		}
+		if action.Type != ActionDividend && action.Type != ActionSplit {
+			return nil, fmt.Errorf("%w: %s on row %d", ErrUnknownActionType, action.Type, idx+1)
+		}
+	}
+
+	return actions, nil
+}
+
+// ImportCorporateActions writes actions into eod.dividend and eod.split_factor
+// in a single transaction. opts may be nil to use the defaults.
+func ImportCorporateActions(ctx context.Context, conn PgxIface, actions []*CorporateAction, opts *ImportOptions) (*ImportSummary, error) {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("could not begin db transaction to import corporate actions")
+		return nil, err
+	}
+
+	summary := &ImportSummary{
+		Results:  make([]*ActionImportResult, 0, len(actions)),
+		Affected: make([]string, 0),
+	}
+	affected := make(map[string]bool)
+
+	for _, action := range actions {
+		result, err := importCorporateAction(ctx, tx, action, opts)
+		if err != nil {
+			if err2 := tx.Rollback(ctx); err2 != nil {
+				log.Error().Err(err2).Msg("failed to rollback db transaction")
+			}
+			return nil, err
+		}
+		summary.Results = append(summary.Results, result)
+		if result.Status == ImportInserted || result.Status == ImportUpdated {
+			affected[result.CompositeFigi] = true
+		}
+	}
+
+	for figi := range affected {
+		summary.Affected = append(summary.Affected, figi)
+	}
+	sort.Strings(summary.Affected)
+
+	if opts.DryRun {
+		if err := tx.Rollback(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to rollback db transaction")
+			return nil, err
+		}
+		return summary, nil
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msg("could not commit corporate actions to database")
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// importCorporateAction matches action to an eod row and updates it
+func importCorporateAction(ctx context.Context, tx pgx.Tx, action *CorporateAction, opts *ImportOptions) (*ActionImportResult, error) {
+	result := &ActionImportResult{
+		Action:        action,
+		CompositeFigi: action.CompositeFigi,
+	}
+
+	// a date quoted under two tickers of one composite figi matches the
+	// quote of the ticker in effect; only rows of different composite figis
+	// are ambiguous
+	var rows pgx.Rows
+	var err error
+	if action.CompositeFigi != "" {
+		rows, err = tx.Query(ctx, `SELECT DISTINCT ON (composite_figi) composite_figi, dividend, split_factor FROM eod WHERE composite_figi = $1 AND event_date = $2 ORDER BY composite_figi, `+tickerInEffect+`, ticker`, action.CompositeFigi, action.EventDate)
+	} else {
+		rows, err = tx.Query(ctx, `SELECT DISTINCT ON (composite_figi) composite_figi, dividend, split_factor FROM eod WHERE ticker = $1 AND event_date = $2 ORDER BY composite_figi, `+tickerInEffect+`, ticker`, action.Ticker, action.EventDate)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("CompositeFigi", action.CompositeFigi).Str("Ticker", action.Ticker).Time("EventDate", action.EventDate).Msg("could not query eod for corporate action")
+		return nil, err
+	}
+
+	matches := 0
+	var dividend, splitFactor float64
+	for rows.Next() {
+		if err := rows.Scan(&result.CompositeFigi, &dividend, &splitFactor); err != nil {
+			rows.Close()
+			log.Error().Err(err).Msg("could not scan eod row for corporate action")
+			return nil, err
+		}
+		matches++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Str("CompositeFigi", action.CompositeFigi).Str("Ticker", action.Ticker).Time("EventDate", action.EventDate).Msg("could not read eod for corporate action")
+		return nil, err
+	}
+
+	switch {
+	case matches == 0:
+		result.Status = ImportUnmatched
+		return result, nil
+	case matches > 1:
+		result.Status = ImportAmbiguous
+		return result, nil
+	}
+
+	// a dividend of 0 or a split factor of 1 means no action is stored
+	column := "dividend"
+	result.Stored = dividend
+	empty := 0.0
+	if action.Type == ActionSplit {
+		column = "split_factor"
+		result.Stored = splitFactor
+		empty = 1.0
+	}
+
+	switch {
+	case result.Stored == action.Value:
+		result.Status = ImportUnchanged
+		return result, nil
+	case result.Stored == empty:
+		result.Status = ImportInserted
+	case opts.Overwrite:
+		result.Status = ImportUpdated
+	default:
+		result.Status = ImportConflict
+		log.Warn().Str("CompositeFigi", result.CompositeFigi).Time("EventDate", action.EventDate).Str("Type", action.Type).Float64("Stored", result.Stored).Float64("Imported", action.Value).Msg("corporate action conflicts with stored value")
+		return result, nil
+	}
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`UPDATE eod SET %s = $1 WHERE composite_figi = $2 AND event_date = $3`, column), action.Value, result.CompositeFigi, action.EventDate); err != nil {
+		log.Error().Err(err).Str("CompositeFigi", result.CompositeFigi).Time("EventDate", action.EventDate).Str("Type", action.Type).Msg("could not update eod with corporate action")
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// PrintImportResults writes a table of results to w
+func PrintImportResults(w io.Writer, results []*ActionImportResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CompositeFigi\tTicker\tDate\tType\tStored\tImported\tStatus")
+	for _, result := range results {
+		action := result.Action
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%g\t%g\t%s\n", result.CompositeFigi, action.Ticker, action.EventDate.Format("2006-01-02"), action.Type, result.Stored, action.Value, result.Status)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,143 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("corporate action import", func() {
+	var (
+		ctx     context.Context
+		mock    pgxmock.PgxConnIface
+		day1    time.Time
+		day2    time.Time
+		actions []*eod.CorporateAction
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+
+		day1 = time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)
+		day2 = time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC)
+
+		actions, err = eod.LoadCorporateActions(strings.NewReader(`composite_figi,ticker,date,type,value
+FIGI1,,2021-01-04,dividend,0.5
+,TEST2,2021-01-05,split,2
+FIGI1,,2021-01-05,dividend,0.25
+,MISSING,2021-01-05,split,3
+`))
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	expectDividendLookup := func() {
+		columns := []string{"composite_figi", "dividend", "split_factor"}
+		mock.ExpectQuery("^SELECT DISTINCT ON \\(composite_figi\\) (.+) FROM eod WHERE composite_figi = (.+) AND event_date = (.+) ORDER BY composite_figi, ticker IS DISTINCT FROM (.+), ticker$").
+			WithArgs("FIGI1", day1).
+			WillReturnRows(mock.NewRows(columns).AddRow("FIGI1", 0.0, 1.0))
+	}
+
+	It("should parse csv files", func() {
+		Expect(actions).To(HaveLen(4))
+		Expect(actions[1].Ticker).To(Equal("TEST2"))
+		Expect(actions[1].EventDate).To(Equal(day2))
+		Expect(actions[1].Type).To(Equal(eod.ActionSplit))
+		Expect(actions[1].Value).To(Equal(2.0))
+	})
+
+	It("should reject unknown action types", func() {
+		_, err := eod.LoadCorporateActions(strings.NewReader("composite_figi,ticker,date,type,value\nFIGI1,,2021-01-04,merger,1\n"))
+		Expect(err).To(MatchError(eod.ErrUnknownActionType))
+	})
+
+	It("should insert new actions and report conflicts", func() {
+		columns := []string{"composite_figi", "dividend", "split_factor"}
+		mock.ExpectBegin()
+		expectDividendLookup()
+		mock.ExpectExec("^UPDATE eod SET dividend = (.+) WHERE composite_figi = (.+) AND event_date = (.+)$").
+			WithArgs(0.5, "FIGI1", day1).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE ticker = (.+) AND event_date = (.+)$").
+			WithArgs("TEST2", day2).
+			WillReturnRows(mock.NewRows(columns).AddRow("FIGI2", 0.0, 1.0))
+		mock.ExpectExec("^UPDATE eod SET split_factor = (.+) WHERE composite_figi = (.+) AND event_date = (.+)$").
+			WithArgs(2.0, "FIGI2", day2).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) AND event_date = (.+)$").
+			WithArgs("FIGI1", day2).
+			WillReturnRows(mock.NewRows(columns).AddRow("FIGI1", 0.3, 1.0))
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE ticker = (.+) AND event_date = (.+)$").
+			WithArgs("MISSING", day2).
+			WillReturnRows(mock.NewRows(columns))
+		mock.ExpectCommit()
+
+		summary, err := eod.ImportCorporateActions(ctx, mock, actions, nil)
+		Expect(err).To(BeNil())
+		Expect(summary.Results[0].Status).To(Equal(eod.ImportInserted))
+		Expect(summary.Results[1].Status).To(Equal(eod.ImportInserted))
+		Expect(summary.Results[1].CompositeFigi).To(Equal("FIGI2"))
+		Expect(summary.Results[2].Status).To(Equal(eod.ImportConflict))
+		Expect(summary.Results[2].Stored).To(Equal(0.3))
+		Expect(summary.Results[3].Status).To(Equal(eod.ImportUnmatched))
+		Expect(summary.Affected).To(Equal([]string{"FIGI1", "FIGI2"}))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should overwrite conflicts when requested", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) AND event_date = (.+)$").
+			WithArgs("FIGI1", day2).
+			WillReturnRows(mock.NewRows([]string{"composite_figi", "dividend", "split_factor"}).AddRow("FIGI1", 0.3, 1.0))
+		mock.ExpectExec("^UPDATE eod SET dividend = (.+)").
+			WithArgs(0.25, "FIGI1", day2).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+		mock.ExpectCommit()
+
+		summary, err := eod.ImportCorporateActions(ctx, mock, actions[2:3], &eod.ImportOptions{Overwrite: true})
+		Expect(err).To(BeNil())
+		Expect(summary.Results[0].Status).To(Equal(eod.ImportUpdated))
+		Expect(summary.Affected).To(Equal([]string{"FIGI1"}))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should roll back dry runs", func() {
+		mock.ExpectBegin()
+		expectDividendLookup()
+		mock.ExpectExec("^UPDATE eod SET dividend").
+			WithArgs(0.5, "FIGI1", day1).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+		mock.ExpectRollback()
+
+		summary, err := eod.ImportCorporateActions(ctx, mock, actions[:1], &eod.ImportOptions{DryRun: true})
+		Expect(err).To(BeNil())
+		Expect(summary.Count(eod.ImportInserted)).To(Equal(1))
+		Expect(summary.Affected).To(Equal([]string{"FIGI1"}))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
@@ -16,12 +16,21 @@ package eod
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 	"github.com/rs/zerolog/log"
 )
 
+var (
+	ErrInvalidAdjustmentFactor = errors.New("split factor or dividend gives a zero or negative adjustment factor")
+)
+
 type PgxIface interface {
 	Begin(context.Context) (pgx.Tx, error)
 	Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error)
@@ -29,54 +38,374 @@ type PgxIface interface {
 	Query(context.Context, string, ...interface{}) (pgx.Rows, error)
 }
 
+// AdjustAssetEodPrice calculates the CRSP adjusted prices of an asset
 func AdjustAssetEodPrice(ctx context.Context, conn PgxIface, compositeFigi string) ([]*Eod, error) {
+	return AdjustAssetEodPriceWithOptions(ctx, conn, compositeFigi, nil)
+}
+
+// AdjustAssetEodPriceAsOf calculates the adjusted prices of an asset as they
+// would have been calculated on asOf: quotes and corporate actions after asOf
+// are ignored. opts may be nil to use the defaults.
+func AdjustAssetEodPriceAsOf(ctx context.Context, conn PgxIface, compositeFigi string, asOf time.Time, opts *AdjustOptions) ([]*Eod, error) {
+	asOfOpts := AdjustOptions{}
+	if opts != nil {
+		asOfOpts = *opts
+	}
+	asOfOpts.AsOf = asOf
+	return AdjustAssetEodPriceWithOptions(ctx, conn, compositeFigi, &asOfOpts)
+}
+
+// AdjustAssetEodPriceWithOptions calculates the adjusted prices of an asset
+// using the methodology in opts. opts may be nil to use the defaults.
+func AdjustAssetEodPriceWithOptions(ctx context.Context, conn PgxIface, compositeFigi string, opts *AdjustOptions) ([]*Eod, error) {
+	if opts == nil {
+		opts = &AdjustOptions{}
+	}
+
 	adjustHistory := make([]*Eod, 0)
-	adjustFactor := 1.0
+	if err := CheckSeries(opts); err != nil {
+		return adjustHistory, err
+	}
+
+	quarantined := make([]*QuarantineRecord, 0)
+	splitFactor := newCumulativeFactor(opts.Arithmetic)
+	dividendFactor := newCumulativeFactor(opts.Arithmetic)
+	netDividendFactor := newCumulativeFactor(opts.Arithmetic)
+
+	policy := opts.ZeroPricePolicy
+	if policy == "" {
+		policy = CarryFactor
+	}
+
+	withholdingRate := 0.0
+	for _, s := range opts.Series {
+		if s == NetTotalReturnSeries {
+			var err error
+			if withholdingRate, err = LoadWithholdingRate(ctx, conn, compositeFigi); err != nil {
+				return adjustHistory, err
+			}
+		}
+	}
+
+	var actions map[string]*DailyActions
+	if opts.ActionSource == TableActions {
+		var err error
+		if actions, err = LoadActionsFromTable(ctx, conn, compositeFigi); err != nil {
+			return adjustHistory, err
+		}
+		if !opts.AsOf.IsZero() {
+			for key, day := range actions {
+				if day.ExDate.After(opts.AsOf) {
+					delete(actions, key)
+				}
+			}
+		}
+	}
 
-	rows, err := conn.Query(ctx, "SELECT event_date, ticker, composite_figi, close, dividend, split_factor FROM eod WHERE composite_figi = $1 ORDER BY ticker, event_date DESC", compositeFigi)
+	quotes, err := loadAssetQuotes(ctx, conn, compositeFigi)
 	if err != nil {
-		log.Error().Err(err).Msg("SELECT all query error")
 		return adjustHistory, err
 	}
 
-	for rows.Next() {
-		var myEod Eod
-		err = rows.Scan(&myEod.EventDate, &myEod.Ticker, &myEod.CompositeFigi, &myEod.Close, &myEod.Dividend, &myEod.SplitFactor)
-		if err != nil {
-			log.Error().Err(err).Msg("could not scan result into eod")
-			return adjustHistory, err
+	// rights are priced against the cum-rights close, which is only known
+	// once the quote before the ex-date is read
+	type pendingRights struct {
+		rights *RightsOffering
+		exEod  *Eod
+	}
+	pending := make([]pendingRights, 0)
+
+	var abortErr error
+	for _, quote := range quotes {
+		myEod := quote.eod
+		closePrice := quote.close
+		myEod.Close = closePrice.Float
+		myEod.MissingClose = closePrice.Status != pgtype.Present
+
+		if !opts.AsOf.IsZero() && myEod.EventDate.After(opts.AsOf) {
+			continue
+		}
+
+		if actions != nil {
+			myEod.Dividends = make(map[DividendType]float64)
+			myEod.SplitFactor = 1
+			key := myEod.EventDate.Format("2006-01-02")
+			if day, ok := actions[key]; ok {
+				myEod.Dividends = day.Dividends
+				myEod.Distributions = day.Distributions
+				myEod.Rights = day.Rights
+				myEod.SplitFactor = day.SplitFactor
+				delete(actions, key)
+			}
+		} else {
+			myEod.Dividends = map[DividendType]float64{RegularDividend: myEod.Dividend}
+		}
+		myEod.Dividend = includedDividends(myEod.Dividends, opts.ExcludedDividends)
+		for _, dist := range myEod.Distributions {
+			myEod.Distribution += dist.Value()
+		}
+
+		// a factor of zero or less would wipe out or flip the sign of every
+		// earlier adjusted price
+		if myEod.SplitFactor <= 0 || (myEod.Close > 0 && myEod.Dividend <= -myEod.Close) {
+			abortErr = fmt.Errorf("%w: %s on %s", ErrInvalidAdjustmentFactor, compositeFigi, myEod.EventDate.Format("2006-01-02"))
+			break
+		}
+
+		if reason := quarantineReason(closePrice); reason != "" {
+			log.Warn().Str("CompositeFigi", compositeFigi).Str("Ticker", myEod.Ticker).Time("EventDate", myEod.EventDate).Str("Reason", reason).Str("Policy", string(policy)).Msg("quarantining eod quote")
+			quarantined = append(quarantined, &QuarantineRecord{
+				CompositeFigi: myEod.CompositeFigi,
+				EventDate:     myEod.EventDate,
+				Ticker:        myEod.Ticker,
+				Close:         closePrice,
+				Reason:        reason,
+				Policy:        policy,
+			})
+
+			if policy == AbortAsset {
+				abortErr = fmt.Errorf("%w: %s on %s", ErrInvalidClose, compositeFigi, myEod.EventDate.Format("2006-01-02"))
+				break
+			}
+
+			// the dividend can't be converted into a factor without a
+			// price and is dropped but splits still apply to earlier quotes
+			if policy == SkipRow {
+				splitFactor.scale(myEod.SplitFactor)
+				continue
+			}
+			myEod.Dividend = 0
+			myEod.Distribution = 0
+			myEod.Distributions = nil
+		}
+
+		// like CRSP, rights are a price factor; the total return series
+		// divides by the price factor as well so they stay out of the
+		// dividend factors
+		if myEod.Close > 0 {
+			for _, item := range pending {
+				splitFactor.scale(item.rights.Factor(myEod.Close))
+				item.exEod.Distribution += item.rights.Value(myEod.Close)
+			}
+			pending = pending[:0]
 		}
 
-		myEod.AdjClose = myEod.Close / adjustFactor
-		// CRSP adjustment calculations
+		myEod.CumSplitFactor = splitFactor.value()
+		myEod.CumDividendFactor = dividendFactor.value()
+		myEod.CumNetDividendFactor = netDividendFactor.value()
+		myEod.SplitAdjClose = splitFactor.deflate(myEod.Close)
+		myEod.NetAdjClose = splitFactor.deflate(myEod.Close, netDividendFactor)
+		// CRSP adjustment calculations; spin-offs are valued like cash
+		// dividends but enter the price factor with the splits so the
+		// split adjusted close has no cliff on the ex-date
 		// see: http://crsp.org/products/documentation/crsp-calculations
 		if myEod.Close > 0 {
-			adjustFactor *= (1 + (myEod.Dividend / myEod.Close)) * myEod.SplitFactor
-		} else {
-			adjustFactor = 1
+			dividendFactor.scaleDistribution(myEod.Dividend, myEod.Close)
+			// withholding tax only applies to the taxable dividend types
+			netDividend := myEod.Dividend - taxableDividends(myEod.Dividends, opts.ExcludedDividends)*withholdingRate
+			netDividendFactor.scaleDistribution(netDividend, myEod.Close)
+			splitFactor.scaleDistribution(myEod.Distribution, myEod.Close)
+		}
+		splitFactor.scale(myEod.SplitFactor)
+
+		for _, rights := range myEod.Rights {
+			pending = append(pending, pendingRights{rights: rights, exEod: &myEod})
 		}
 
 		adjustHistory = append(adjustHistory, &myEod)
 	}
 
+	if len(quarantined) > 0 && !opts.DryRun {
+		if err := SaveQuarantineToDb(ctx, conn, quarantined); err != nil {
+			return adjustHistory, err
+		}
+	}
+
+	if abortErr != nil {
+		return adjustHistory, abortErr
+	}
+
+	for _, day := range actions {
+		log.Warn().Str("CompositeFigi", compositeFigi).Time("ExDate", day.ExDate).Msg("corporate action ex-date has no eod quote and was not applied")
+	}
+
+	var adjuster Adjuster = &CRSPAdjuster{}
+	if opts.Adjuster != nil {
+		adjuster = opts.Adjuster
+	}
+
+	for idx, adj := range adjuster.Adjustments(adjustHistory) {
+		myEod := adjustHistory[idx]
+		myEod.AdjClose = adj.Apply(myEod.Close)
+		myEod.AdjOpen = adj.ApplyFloat8(myEod.Open)
+		myEod.AdjHigh = adj.ApplyFloat8(myEod.High)
+		myEod.AdjLow = adj.ApplyFloat8(myEod.Low)
+		// volume moves inversely to price
+		myEod.AdjVolume = scaleFloat8(myEod.Volume, adj.VolumeMultiplier)
+	}
+
+	if opts.Delisting != "" && opts.Delisting != DelistingIgnore {
+		delisting, err := LoadDelisting(ctx, conn, compositeFigi)
+		if err != nil {
+			return adjustHistory, err
+		}
+		if delisting != nil && (opts.AsOf.IsZero() || !delisting.DelistingDate.After(opts.AsOf)) {
+			return applyDelisting(adjustHistory, delisting, opts.Delisting)
+		}
+	}
+
 	return adjustHistory, nil
 }
 
-// SaveAdjCloseToDb updates database record with adjusted close value
-func SaveAdjCloseToDb(ctx context.Context, conn PgxIface, prices []*Eod) error {
+// tickerInEffect orders an eod quote behind quotes of the ticker the figi
+// traded under on that date, which is the ticker with the latest listing in
+// the assets table on or before the quote's event date
+const tickerInEffect = "ticker IS DISTINCT FROM (SELECT a.ticker FROM assets a WHERE a.composite_figi = eod.composite_figi AND a.listed_utc <= eod.event_date ORDER BY a.listed_utc DESC LIMIT 1)"
+
+// assetQuote is an eod quote with its raw close, which may be NULL
+type assetQuote struct {
+	eod   Eod
+	close pgtype.Float8
+
+	// set when the dividend or split was taken from a duplicate quote
+	mergedDividend bool
+	mergedSplit    bool
+}
+
+// loadAssetQuotes reads the eod quotes of the figi newest first. Prices are
+// adjusted over a single timeline for the figi regardless of the ticker it
+// traded under, so when tickers overlap the quote of the ticker in effect on
+// that date is kept. A dividend or split recorded only on a duplicate quote
+// is carried over to the kept quote. Adjusted prices are saved by figi and
+// date and so are written to the duplicate quotes as well.
+func loadAssetQuotes(ctx context.Context, conn PgxIface, compositeFigi string) ([]*assetQuote, error) {
+	rows, err := conn.Query(ctx, "SELECT event_date, ticker, composite_figi, close, dividend, split_factor, open, high, low, volume::double precision FROM eod WHERE composite_figi = $1 ORDER BY event_date DESC, "+tickerInEffect+", ticker", compositeFigi)
+	if err != nil {
+		log.Error().Err(err).Msg("SELECT all query error")
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotes := make([]*assetQuote, 0)
+	for rows.Next() {
+		quote := &assetQuote{}
+		err = rows.Scan(&quote.eod.EventDate, &quote.eod.Ticker, &quote.eod.CompositeFigi, &quote.close, &quote.eod.Dividend, &quote.eod.SplitFactor, &quote.eod.Open, &quote.eod.High, &quote.eod.Low, &quote.eod.Volume)
+		if err != nil {
+			log.Error().Err(err).Msg("could not scan result into eod")
+			return nil, err
+		}
+
+		if len(quotes) > 0 {
+			kept := quotes[len(quotes)-1]
+			if kept.eod.EventDate.Equal(quote.eod.EventDate) {
+				log.Warn().Str("CompositeFigi", compositeFigi).Str("Ticker", quote.eod.Ticker).Str("KeptTicker", kept.eod.Ticker).Time("EventDate", quote.eod.EventDate).Msg("skipping duplicate eod quote for date")
+				kept.merge(&quote.eod)
+				continue
+			}
+		}
+
+		quotes = append(quotes, quote)
+	}
+
+	return quotes, rows.Err()
+}
+
+// merge copies the dividend and split of dup onto the quote when its own
+// quote has none; of several duplicates the largest value wins
+func (quote *assetQuote) merge(dup *Eod) {
+	if dup.Dividend != 0 && (quote.eod.Dividend == 0 || (quote.mergedDividend && dup.Dividend > quote.eod.Dividend)) {
+		quote.eod.Dividend = dup.Dividend
+		quote.mergedDividend = true
+	}
+	if dup.SplitFactor != 1 && dup.SplitFactor != 0 && (quote.eod.SplitFactor == 1 || quote.eod.SplitFactor == 0 || (quote.mergedSplit && dup.SplitFactor > quote.eod.SplitFactor)) {
+		quote.eod.SplitFactor = dup.SplitFactor
+		quote.mergedSplit = true
+	}
+}
+
+// scaleFloat8 multiplies val by factor, preserving NULL values
+func scaleFloat8(val pgtype.Float8, factor float64) pgtype.Float8 {
+	if val.Status != pgtype.Present {
+		return pgtype.Float8{Status: pgtype.Null}
+	}
+	return pgtype.Float8{Float: val.Float * factor, Status: pgtype.Present}
+}
+
+// BulkSaveThreshold is the number of rows above which SaveAdjCloseToDb
+// streams prices into a staging table with COPY instead of issuing one
+// UPDATE per row
+var BulkSaveThreshold = 500
+
+// SaveAdjCloseToDb updates database record with the requested adjusted series
+// (adj_close when none are given) and replaces the assets' rows in
+// eod_adjustment_factors. All prices are written in a single transaction; if
+// any of them fail the entire update is rolled back. Synthetic quotes are
+// skipped. Changed adj_close values are recorded in eod_adj_close_history
+// without a run or reason.
+func SaveAdjCloseToDb(ctx context.Context, conn PgxIface, prices []*Eod, series ...Series) error {
+	return SaveAdjCloseRevision(ctx, conn, prices, nil, series...)
+}
+
+// SaveAdjCloseRevision is SaveAdjCloseToDb with the changed adj_close values
+// recorded in eod_adj_close_history under revision. revision may be nil; when
+// it has a run the overwritten values are kept in adjust_run_changes and
+// adjust_run_factors so the run can be undone with RollbackRun.
+func SaveAdjCloseRevision(ctx context.Context, conn PgxIface, prices []*Eod, revision *Revision, series ...Series) error {
+	if len(series) == 0 {
+		series = []Series{TotalReturnSeries}
+	}
+
+	stored := make([]*Eod, 0, len(prices))
+	for _, myEod := range prices {
+		if !myEod.Synthetic {
+			stored = append(stored, myEod)
+		}
+	}
+	prices = stored
+
 	tx, err := conn.Begin(ctx)
 	if err != nil {
 		log.Error().Err(err).Msg("could not begin db transaction to adjust eod prices")
+		return err
 	}
 
-	for _, myEod := range prices {
-		if _, err := tx.Exec(ctx, "UPDATE eod SET adj_close=$1 WHERE composite_figi=$2 AND event_date=$3", myEod.AdjClose, myEod.CompositeFigi, myEod.EventDate); err != nil {
-			log.Error().Err(err).Str("Ticker", myEod.Ticker).Float64("AdjustedClose", myEod.AdjClose).Float64("Close", myEod.Close).Time("EventDate", myEod.EventDate).Msg("failed to update eod")
-			if err2 := tx.Rollback(ctx); err2 != nil {
-				log.Error().Err(err2).Msg("failed to rollback db transaction")
-			}
-			return err
+	// a run compares the staged prices with eod to record what it changes;
+	// without a staging table the history is limited to the saved dates
+	figis := assetFigis(prices)
+	var dates map[string][]time.Time
+	if len(prices) > BulkSaveThreshold || (revision != nil && revision.RunID != 0) {
+		err = stageAdjClose(ctx, tx, prices)
+		if err == nil {
+			err = saveRunChanges(ctx, tx, "", nil, "eod_adj_close_staging", series, revision)
+		}
+		if err == nil {
+			err = saveStagedAdjClose(ctx, tx, len(prices), series)
+		}
+		if err == nil {
+			err = saveRunFactors(ctx, tx, figis, revision)
+		}
+	} else {
+		err = saveAdjCloseRows(ctx, tx, prices, series)
+		dates = make(map[string][]time.Time, len(figis))
+		for _, myEod := range prices {
+			dates[myEod.CompositeFigi] = append(dates[myEod.CompositeFigi], myEod.EventDate)
+		}
+	}
+
+	var reasons map[string]string
+	if err == nil {
+		reasons, err = saveAdjustmentFactors(ctx, tx, prices)
+	}
+
+	if err == nil {
+		err = saveAdjCloseHistory(ctx, tx, figis, dates, revision, reasons)
+	}
+
+	if err != nil {
+		if err2 := tx.Rollback(ctx); err2 != nil {
+			log.Error().Err(err2).Msg("failed to rollback db transaction")
 		}
+		return err
 	}
 
 	if err := tx.Commit(ctx); err != nil {
@@ -86,3 +415,172 @@ func SaveAdjCloseToDb(ctx context.Context, conn PgxIface, prices []*Eod) error {
 
 	return nil
 }
+
+// assetFigis returns the composite figi of each asset in prices once, in the
+// order they first appear
+func assetFigis(prices []*Eod) []string {
+	seen := make(map[string]bool)
+	figis := make([]string, 0)
+	for _, myEod := range prices {
+		if !seen[myEod.CompositeFigi] {
+			seen[myEod.CompositeFigi] = true
+			figis = append(figis, myEod.CompositeFigi)
+		}
+	}
+	return figis
+}
+
+// saveAdjCloseRows updates each eod row individually
+func saveAdjCloseRows(ctx context.Context, tx pgx.Tx, prices []*Eod, series []Series) error {
+	sets := make([]string, 0, len(series))
+	for _, s := range series {
+		for _, col := range s.Columns() {
+			sets = append(sets, fmt.Sprintf("%s=$%d", col, len(sets)+1))
+		}
+	}
+	sql := fmt.Sprintf("UPDATE eod SET %s WHERE composite_figi=$%d AND event_date=$%d", strings.Join(sets, ", "), len(sets)+1, len(sets)+2)
+
+	for _, myEod := range prices {
+		args := make([]interface{}, 0, len(sets)+2)
+		for _, s := range series {
+			args = append(args, s.Values(myEod)...)
+		}
+		args = append(args, myEod.CompositeFigi, myEod.EventDate)
+
+		if _, err := tx.Exec(ctx, sql, args...); err != nil {
+			log.Error().Err(err).Str("Ticker", myEod.Ticker).Float64("AdjustedClose", myEod.AdjClose).Float64("SplitAdjustedClose", myEod.SplitAdjClose).Float64("Close", myEod.Close).Time("EventDate", myEod.EventDate).Msg("failed to update eod")
+			return err
+		}
+	}
+	return nil
+}
+
+// createAdjCloseStaging creates the temporary table eod_adj_close_staging
+// with every adjusted series and the cumulative factors and returns its
+// columns
+func createAdjCloseStaging(ctx context.Context, tx pgx.Tx) ([]string, error) {
+	columns := []string{"composite_figi", "event_date"}
+	defs := []string{"composite_figi text", "event_date date"}
+	for _, s := range AllSeries {
+		for _, col := range s.Columns() {
+			columns = append(columns, col)
+			defs = append(defs, col+" double precision")
+		}
+	}
+	for _, col := range runFactorColumns {
+		columns = append(columns, col)
+		defs = append(defs, col+" double precision")
+	}
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`CREATE TEMPORARY TABLE eod_adj_close_staging (%s) ON COMMIT DROP`, strings.Join(defs, ", "))); err != nil {
+		log.Error().Err(err).Msg("could not create adj_close staging table")
+		return nil, err
+	}
+
+	return columns, nil
+}
+
+// stageAdjClose copies prices, every adjusted series and the cumulative
+// factors, into the temporary staging table
+func stageAdjClose(ctx context.Context, tx pgx.Tx, prices []*Eod) error {
+	columns, err := createAdjCloseStaging(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, len(prices))
+	for idx, myEod := range prices {
+		rows[idx] = []interface{}{myEod.CompositeFigi, myEod.EventDate}
+		for _, s := range AllSeries {
+			rows[idx] = append(rows[idx], s.Values(myEod)...)
+		}
+		rows[idx] = append(rows[idx], myEod.CumSplitFactor, myEod.CumDividendFactor)
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"eod_adj_close_staging"}, columns, pgx.CopyFromRows(rows)); err != nil {
+		log.Error().Err(err).Int("NumRows", len(rows)).Msg("could not copy adjusted close prices to staging table")
+		return err
+	}
+
+	return nil
+}
+
+// saveStagedAdjClose applies the requested series of the prices staged by
+// stageAdjClose to eod with a single UPDATE ... FROM
+func saveStagedAdjClose(ctx context.Context, tx pgx.Tx, numRows int, series []Series) error {
+	sets := make([]string, 0, len(series))
+	for _, s := range series {
+		for _, col := range s.Columns() {
+			sets = append(sets, fmt.Sprintf("%[1]s = s.%[1]s", col))
+		}
+	}
+	sql := fmt.Sprintf("UPDATE eod SET %s FROM eod_adj_close_staging s WHERE eod.composite_figi = s.composite_figi AND eod.event_date = s.event_date", strings.Join(sets, ", "))
+
+	if _, err := tx.Exec(ctx, sql); err != nil {
+		log.Error().Err(err).Int("NumRows", numRows).Msg("could not update eod from adj_close staging table")
+		return err
+	}
+
+	return nil
+}
+
+// saveAdjustmentFactors replaces the assets' rows in eod_adjustment_factors
+// and returns the corporate actions that changed for each asset, derived by
+// comparing the replaced factors with the new ones
+func saveAdjustmentFactors(ctx context.Context, tx pgx.Tx, prices []*Eod) (map[string]string, error) {
+	assetPrices := make(map[string][]*Eod)
+	rows := make([][]interface{}, len(prices))
+	for idx, myEod := range prices {
+		assetPrices[myEod.CompositeFigi] = append(assetPrices[myEod.CompositeFigi], myEod)
+		rows[idx] = []interface{}{myEod.CompositeFigi, myEod.EventDate, myEod.CumSplitFactor, myEod.CumDividendFactor}
+	}
+
+	reasons := make(map[string]string)
+	for _, compositeFigi := range assetFigis(prices) {
+		stored, err := deleteAdjustmentFactors(ctx, tx, compositeFigi)
+		if err != nil {
+			return nil, err
+		}
+		if len(stored) == 0 {
+			reasons[compositeFigi] = "initial adjustment"
+		} else {
+			reasons[compositeFigi] = describeFactorChanges(assetFactorChanges(stored, assetPrices[compositeFigi]))
+		}
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"eod_adjustment_factors"}, []string{"composite_figi", "event_date", "split_factor", "dividend_factor"}, pgx.CopyFromRows(rows)); err != nil {
+		log.Error().Err(err).Int("NumRows", len(rows)).Msg("could not copy adjustment factors to database")
+		return nil, err
+	}
+
+	return reasons, nil
+}
+
+// deleteAdjustmentFactors deletes an asset's rows in eod_adjustment_factors
+// and returns the deleted split and dividend factors keyed by YYYY-MM-DD
+func deleteAdjustmentFactors(ctx context.Context, tx pgx.Tx, compositeFigi string) (map[string][2]float64, error) {
+	stored := make(map[string][2]float64)
+
+	rows, err := tx.Query(ctx, `DELETE FROM eod_adjustment_factors WHERE composite_figi = $1 RETURNING event_date, split_factor, dividend_factor`, compositeFigi)
+	if err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not delete adjustment factors")
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var eventDate time.Time
+		var splitFactor, dividendFactor float64
+		if err := rows.Scan(&eventDate, &splitFactor, &dividendFactor); err != nil {
+			log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not scan deleted adjustment factors")
+			return nil, err
+		}
+		stored[eventDate.Format("2006-01-02")] = [2]float64{splitFactor, dividendFactor}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not delete adjustment factors")
+		return nil, err
+	}
+	return stored, nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgtype"
+)
+
+var (
+	ErrUnknownAdjuster = errors.New("unknown adjustment method")
+)
+
+// PriceAdjustment converts a raw price into an adjusted price with
+// price * Multiplier + Offset and a raw volume into an adjusted volume with
+// volume * VolumeMultiplier
+type PriceAdjustment struct {
+	Multiplier       float64
+	Offset           float64
+	VolumeMultiplier float64
+}
+
+// Apply returns the adjusted price
+func (adj PriceAdjustment) Apply(price float64) float64 {
+	return price*adj.Multiplier + adj.Offset
+}
+
+// ApplyFloat8 returns the adjusted price, preserving NULL values
+func (adj PriceAdjustment) ApplyFloat8(price pgtype.Float8) pgtype.Float8 {
+	if price.Status != pgtype.Present {
+		return pgtype.Float8{Status: pgtype.Null}
+	}
+	return pgtype.Float8{Float: adj.Apply(price.Float), Status: pgtype.Present}
+}
+
+// Adjuster implements an adjustment methodology
+type Adjuster interface {
+	// Adjustments returns the adjustment for each quote in history. history
+	// is sorted newest first and has CumSplitFactor and CumDividendFactor
+	// set on every quote.
+	Adjustments(history []*Eod) []PriceAdjustment
+}
+
+// ParseAdjuster returns the Adjuster with the given name
+func ParseAdjuster(name string) (Adjuster, error) {
+	switch name {
+	case "crsp":
+		return &CRSPAdjuster{}, nil
+	case "additive":
+		return &AdditiveAdjuster{}, nil
+	case "forward":
+		return &ForwardAdjuster{}, nil
+	case "split":
+		return &SplitOnlyAdjuster{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAdjuster, name)
+	}
+}
+
+// CRSPAdjuster back-adjusts prices by dividing by the cumulative split and
+// dividend factors so the most recent price is unchanged
+type CRSPAdjuster struct{}
+
+func (a *CRSPAdjuster) Adjustments(history []*Eod) []PriceAdjustment {
+	adjustments := make([]PriceAdjustment, len(history))
+	for idx, myEod := range history {
+		adjustments[idx] = PriceAdjustment{
+			Multiplier:       1 / (myEod.CumSplitFactor * myEod.CumDividendFactor),
+			VolumeMultiplier: myEod.CumSplitFactor,
+		}
+	}
+	return adjustments
+}
+
+// AdditiveAdjuster back-adjusts prices for splits and subtracts the
+// (split adjusted) amount of every later dividend so that price differences,
+// rather than returns, are preserved
+type AdditiveAdjuster struct{}
+
+func (a *AdditiveAdjuster) Adjustments(history []*Eod) []PriceAdjustment {
+	adjustments := make([]PriceAdjustment, len(history))
+	offset := 0.0
+	for idx, myEod := range history {
+		adjustments[idx] = PriceAdjustment{
+			Multiplier:       1 / myEod.CumSplitFactor,
+			Offset:           offset,
+			VolumeMultiplier: myEod.CumSplitFactor,
+		}
+		// non-cash distributions are in the cumulative split factor already
+		offset -= myEod.Dividend / myEod.CumSplitFactor
+	}
+	return adjustments
+}
+
+// ForwardAdjuster anchors the adjusted series to the first trading day and
+// adjusts later prices forward so the oldest price is unchanged
+type ForwardAdjuster struct{}
+
+func (a *ForwardAdjuster) Adjustments(history []*Eod) []PriceAdjustment {
+	adjustments := make([]PriceAdjustment, len(history))
+	if len(history) == 0 {
+		return adjustments
+	}
+
+	first := history[len(history)-1]
+	for idx, myEod := range history {
+		adjustments[idx] = PriceAdjustment{
+			Multiplier:       (first.CumSplitFactor * first.CumDividendFactor) / (myEod.CumSplitFactor * myEod.CumDividendFactor),
+			VolumeMultiplier: myEod.CumSplitFactor / first.CumSplitFactor,
+		}
+	}
+	return adjustments
+}
+
+// SplitOnlyAdjuster back-adjusts prices for splits and ignores dividends
+type SplitOnlyAdjuster struct{}
+
+func (a *SplitOnlyAdjuster) Adjustments(history []*Eod) []PriceAdjustment {
+	adjustments := make([]PriceAdjustment, len(history))
+	for idx, myEod := range history {
+		adjustments[idx] = PriceAdjustment{
+			Multiplier:       1 / myEod.CumSplitFactor,
+			VolumeMultiplier: myEod.CumSplitFactor,
+		}
+	}
+	return adjustments
+}
@@ -0,0 +1,93 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("adjustment methods", func() {
+	// history (newest first):
+	//   2021-01-04 close 10
+	//   2021-01-03 close 10, $1 dividend -> dividend factor 1 + 1/10 = 1.1
+	//   2021-01-02 close 20, 2:1 split   -> split factor 2
+	//   2021-01-01 close 22
+	DescribeTable("adjusted close",
+		func(method string, expected []float64, expectedVolume []float64) {
+			ctx := context.Background()
+			mock, err := pgxmock.NewConn()
+			Expect(err).To(BeNil())
+			defer mock.Close(ctx)
+
+			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+				AddRow(time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), "TEST", "TEST", 10.0, 0.0, 1.0, 10.0, nil, nil, 100.0).
+				AddRow(time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC), "TEST", "TEST", 10.0, 1.0, 1.0, 10.0, nil, nil, 100.0).
+				AddRow(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), "TEST", "TEST", 20.0, 0.0, 2.0, 20.0, nil, nil, 100.0).
+				AddRow(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), "TEST", "TEST", 22.0, 0.0, 1.0, 22.0, nil, nil, 100.0)
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+)").WillReturnRows(rows)
+
+			adjuster, err := eod.ParseAdjuster(method)
+			Expect(err).To(BeNil())
+
+			prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{Adjuster: adjuster})
+			Expect(err).To(BeNil())
+			Expect(prices).To(HaveLen(len(expected)))
+
+			for idx, price := range prices {
+				Expect(price.AdjClose).To(BeNumerically("~", expected[idx], 1e-9), "AdjClose on %s", price.EventDate)
+				Expect(price.AdjOpen.Float).To(BeNumerically("~", expected[idx], 1e-9), "AdjOpen on %s", price.EventDate)
+				Expect(price.AdjVolume.Float).To(BeNumerically("~", expectedVolume[idx], 1e-9), "AdjVolume on %s", price.EventDate)
+			}
+		},
+		// close / (split factor * dividend factor)
+		Entry("crsp", "crsp", []float64{10, 10, 20 / 1.1, 22 / 2.2}, []float64{100, 100, 100, 200}),
+		// close / split factor - later dividends / split factor
+		Entry("additive", "additive", []float64{10, 10, 20 - 1, 22.0/2 - 1}, []float64{100, 100, 100, 200}),
+		// close * (first day factor 2.2) / factor
+		Entry("forward", "forward", []float64{10 * 2.2, 10 * 2.2, 20 * 2.2 / 1.1, 22}, []float64{50, 50, 50, 100}),
+		// close / split factor
+		Entry("split", "split", []float64{10, 10, 20, 11}, []float64{100, 100, 100, 200}),
+	)
+
+	It("should reject unknown methods", func() {
+		_, err := eod.ParseAdjuster("bogus")
+		Expect(err).To(MatchError(eod.ErrUnknownAdjuster))
+	})
+
+	It("should only write the split and net series with the crsp method", func() {
+		additive, err := eod.ParseAdjuster("additive")
+		Expect(err).To(BeNil())
+
+		Expect(eod.CheckSeries(&eod.AdjustOptions{Series: eod.AllSeries})).To(Succeed())
+		Expect(eod.CheckSeries(&eod.AdjustOptions{Adjuster: &eod.CRSPAdjuster{}, Series: eod.AllSeries})).To(Succeed())
+		Expect(eod.CheckSeries(&eod.AdjustOptions{Adjuster: additive, Series: []eod.Series{eod.TotalReturnSeries}})).To(Succeed())
+		Expect(eod.CheckSeries(&eod.AdjustOptions{Adjuster: additive, Series: []eod.Series{eod.SplitSeries}})).To(MatchError(eod.ErrUnsupportedSeries))
+		Expect(eod.CheckSeries(&eod.AdjustOptions{Adjuster: additive, Series: []eod.Series{eod.NetTotalReturnSeries}})).To(MatchError(eod.ErrUnsupportedSeries))
+
+		// the asset fails before any quotes are loaded
+		mock, err := pgxmock.NewConn()
+		Expect(err).To(BeNil())
+		defer mock.Close(context.Background())
+		_, err = eod.AdjustAssetEodPriceWithOptions(context.Background(), mock, "TEST", &eod.AdjustOptions{Adjuster: additive, Series: []eod.Series{eod.SplitSeries}})
+		Expect(err).To(MatchError(eod.ErrUnsupportedSeries))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
@@ -0,0 +1,136 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	ErrUnknownArithmetic = errors.New("unknown arithmetic")
+)
+
+// Arithmetic selects the number type cumulative adjustment factors are
+// accumulated in
+type Arithmetic string
+
+const (
+	// FloatArithmetic multiplies factors as float64; rounding errors compound
+	// with every quote
+	FloatArithmetic Arithmetic = "float"
+
+	// BigArithmetic multiplies factors as big.Float with BigFloatPrecision
+	// bits of mantissa and only rounds to float64 when a value is stored
+	BigArithmetic Arithmetic = "big"
+)
+
+// BigFloatPrecision is the mantissa size, in bits, used by BigArithmetic
+var BigFloatPrecision uint = 256
+
+// ParseArithmetic returns the Arithmetic with the given name
+func ParseArithmetic(name string) (Arithmetic, error) {
+	switch Arithmetic(name) {
+	case FloatArithmetic, BigArithmetic:
+		return Arithmetic(name), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownArithmetic, name)
+	}
+}
+
+// cumulativeFactor is a running product of adjustment factors
+type cumulativeFactor interface {
+	// scale multiplies the factor by x
+	scale(x float64)
+
+	// scaleDistribution multiplies the factor by 1 + amount/price
+	scaleDistribution(amount, price float64)
+
+	// deflate returns price divided by the product of the factor and others,
+	// which must use the same arithmetic
+	deflate(price float64, others ...cumulativeFactor) float64
+
+	// value returns the factor as a float64
+	value() float64
+}
+
+// newCumulativeFactor returns a factor of 1 using arithmetic; the zero value
+// uses FloatArithmetic
+func newCumulativeFactor(arithmetic Arithmetic) cumulativeFactor {
+	if arithmetic == BigArithmetic {
+		return &bigFactor{val: newBigFloat(1)}
+	}
+	return &floatFactor{val: 1}
+}
+
+type floatFactor struct {
+	val float64
+}
+
+func (f *floatFactor) scale(x float64) {
+	f.val *= x
+}
+
+func (f *floatFactor) scaleDistribution(amount, price float64) {
+	f.val *= 1 + (amount / price)
+}
+
+func (f *floatFactor) deflate(price float64, others ...cumulativeFactor) float64 {
+	divisor := f.val
+	for _, other := range others {
+		divisor *= other.value()
+	}
+	return price / divisor
+}
+
+func (f *floatFactor) value() float64 {
+	return f.val
+}
+
+type bigFactor struct {
+	val *big.Float
+}
+
+func (f *bigFactor) scale(x float64) {
+	f.val.Mul(f.val, newBigFloat(x))
+}
+
+func (f *bigFactor) scaleDistribution(amount, price float64) {
+	term := newBigFloat(amount)
+	term.Quo(term, newBigFloat(price))
+	term.Add(term, newBigFloat(1))
+	f.val.Mul(f.val, term)
+}
+
+func (f *bigFactor) deflate(price float64, others ...cumulativeFactor) float64 {
+	divisor := new(big.Float).SetPrec(BigFloatPrecision).Set(f.val)
+	for _, other := range others {
+		divisor.Mul(divisor, other.(*bigFactor).val)
+	}
+	quotient := newBigFloat(price)
+	result, _ := quotient.Quo(quotient, divisor).Float64()
+	return result
+}
+
+func (f *bigFactor) value() float64 {
+	result, _ := f.val.Float64()
+	return result
+}
+
+// newBigFloat returns x as a big.Float with BigFloatPrecision
+func newBigFloat(x float64) *big.Float {
+	return new(big.Float).SetPrec(BigFloatPrecision).SetFloat64(x)
+}
@@ -0,0 +1,187 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("arbitrary precision arithmetic", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	It("should parse arithmetic names", func() {
+		arithmetic, err := eod.ParseArithmetic("big")
+		Expect(err).To(BeNil())
+		Expect(arithmetic).To(Equal(eod.BigArithmetic))
+
+		_, err = eod.ParseArithmetic("decimal")
+		Expect(err).To(MatchError(eod.ErrUnknownArithmetic))
+	})
+
+	It("should not compound rounding errors over long histories", func() {
+		// a century of daily quotes, each paying a dividend
+		numRows := 25000
+		start := time.Date(1925, 1, 1, 0, 0, 0, 0, time.UTC)
+		closes := make([]float64, numRows)
+		dividends := make([]float64, numRows)
+		for idx := range closes {
+			closes[idx] = 100 + float64(idx%37)*0.37
+			dividends[idx] = 0.013 + float64(idx%11)*0.001
+		}
+
+		expectEod := func() {
+			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"})
+			for idx := numRows - 1; idx >= 0; idx-- {
+				rows.AddRow(start.AddDate(0, 0, idx), "TEST", "TEST", closes[idx], dividends[idx], 1.0, nil, nil, nil, nil)
+			}
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WithArgs("TEST").WillReturnRows(rows)
+		}
+
+		// exact adjusted close of every quote, newest first
+		exact := make([]*big.Float, numRows)
+		factor := new(big.Float).SetPrec(2048).SetInt64(1)
+		for idx := numRows - 1; idx >= 0; idx-- {
+			price := new(big.Float).SetPrec(2048).SetFloat64(closes[idx])
+			exact[numRows-1-idx] = new(big.Float).SetPrec(2048).Quo(price, factor)
+			term := new(big.Float).SetPrec(2048).SetFloat64(dividends[idx])
+			term.Quo(term, price)
+			term.Add(term, new(big.Float).SetPrec(2048).SetInt64(1))
+			factor.Mul(factor, term)
+		}
+
+		maxRelError := func(prices []*eod.Eod) []float64 {
+			errs := make([]float64, len(prices))
+			worst := 0.0
+			for idx, myEod := range prices {
+				diff := new(big.Float).SetPrec(2048).SetFloat64(myEod.AdjClose)
+				diff.Sub(diff, exact[idx])
+				diff.Quo(diff, exact[idx])
+				rel, _ := diff.Float64()
+				worst = math.Max(worst, math.Abs(rel))
+				errs[idx] = worst
+			}
+			return errs
+		}
+
+		expectEod()
+		floatPrices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{Arithmetic: eod.FloatArithmetic})
+		Expect(err).To(BeNil())
+		Expect(floatPrices).To(HaveLen(numRows))
+
+		expectEod()
+		bigPrices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{Arithmetic: eod.BigArithmetic})
+		Expect(err).To(BeNil())
+		Expect(bigPrices).To(HaveLen(numRows))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+
+		floatErrs := maxRelError(floatPrices)
+		bigErrs := maxRelError(bigPrices)
+
+		// the float64 error grows with the length of the history while the
+		// big.Float error stays at a few ulps of the final rounding
+		Expect(floatErrs[numRows-1]).To(BeNumerically(">", 10*floatErrs[numRows/100]))
+		Expect(bigErrs[numRows-1]).To(BeNumerically("<", 1e-15))
+		Expect(bigErrs[numRows-1]).To(BeNumerically("<", floatErrs[numRows-1]/10))
+	})
+
+	It("should compound synthetic history in either arithmetic", func() {
+		fileName := filepath.Join(GinkgoT().TempDir(), "component.csv")
+		lines := []string{"date,adjClose"}
+		start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		for idx := 0; idx < 1000; idx++ {
+			lines = append(lines, fmt.Sprintf("%s,%.4f", start.AddDate(0, 0, idx).Format("2006-01-02"), 50+float64(idx%13)*0.13))
+		}
+		Expect(os.WriteFile(fileName, []byte(strings.Join(lines, "\n")), 0600)).To(Succeed())
+
+		asset := &eod.SyntheticAsset{
+			Symbol:        "SYN",
+			CompositeFigi: "SYN",
+			StartDate:     start,
+			Components:    []*eod.SyntheticComponent{{Name: "component", FileName: fileName}},
+		}
+
+		floatQuotes, err := eod.BuildSyntheticHistory(ctx, asset, nil)
+		Expect(err).To(BeNil())
+		bigQuotes, err := eod.BuildSyntheticHistoryWithArithmetic(ctx, asset, nil, eod.BigArithmetic)
+		Expect(err).To(BeNil())
+
+		Expect(bigQuotes).To(HaveLen(len(floatQuotes)))
+		Expect(bigQuotes[0].Close).To(Equal(1.0))
+		last := len(bigQuotes) - 1
+		Expect(bigQuotes[last].Close).To(BeNumerically("~", (50+float64(999%13)*0.13)/50, 1e-12))
+		Expect(bigQuotes[last].AdjClose).To(Equal(bigQuotes[last].Close))
+		Expect(floatQuotes[last].Close).To(BeNumerically("~", bigQuotes[last].Close, 1e-12))
+	})
+})
+
+var _ = Describe("rounding policy", func() {
+	DescribeTable("should round prices",
+		func(mode eod.RoundingMode, places int, price, expected float64) {
+			Expect(eod.RoundingPolicy{Mode: mode, Places: places}.Round(price)).To(Equal(expected))
+		},
+		Entry("none", eod.RoundNone, 2, 1.23456, 1.23456),
+		Entry("zero value", eod.RoundingMode(""), 2, 1.23456, 1.23456),
+		Entry("half-even", eod.RoundHalfEven, 4, 1.23456789, 1.2346),
+		Entry("half-even tie down", eod.RoundHalfEven, 2, 0.125, 0.12),
+		Entry("half-even tie up", eod.RoundHalfEven, 2, 0.375, 0.38),
+		Entry("half-even whole", eod.RoundHalfEven, 0, 2.5, 2.0),
+		Entry("half-up tie", eod.RoundHalfUp, 2, 0.125, 0.13),
+		Entry("half-up negative tie", eod.RoundHalfUp, 2, -0.125, -0.13),
+		Entry("half-up whole", eod.RoundHalfUp, 0, 2.5, 3.0),
+		Entry("truncate", eod.RoundTruncate, 3, 1.23999, 1.239),
+		Entry("truncate negative", eod.RoundTruncate, 3, -1.23999, -1.239),
+	)
+
+	It("should reject unknown modes", func() {
+		_, err := eod.ParseRoundingMode("ceiling")
+		Expect(err).To(MatchError(eod.ErrUnknownRoundingMode))
+	})
+
+	It("should round adjusted prices and keep NULL values", func() {
+		prices := []*eod.Eod{
+			{AdjClose: 10.123456789, SplitAdjClose: 20.987654321},
+		}
+		eod.RoundPrices(prices, eod.RoundingPolicy{Mode: eod.RoundHalfEven, Places: 6})
+		Expect(prices[0].AdjClose).To(Equal(10.123457))
+		Expect(prices[0].SplitAdjClose).To(Equal(20.987654))
+		Expect(prices[0].AdjOpen.Status).To(Equal(prices[0].Open.Status))
+	})
+})
@@ -0,0 +1,75 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("point-in-time adjustment", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+	)
+
+	day := func(d int) time.Time {
+		return time.Date(2021, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+
+		// dividend on day 2 and a 2:1 split on day 4
+		rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+			AddRow(day(4), "TEST", "TEST", 10.0, 0.0, 2.0, nil, nil, nil, nil).
+			AddRow(day(3), "TEST", "TEST", 20.0, 0.0, 1.0, nil, nil, nil, nil).
+			AddRow(day(2), "TEST", "TEST", 20.0, 1.0, 1.0, nil, nil, nil, nil).
+			AddRow(day(1), "TEST", "TEST", 21.0, 0.0, 1.0, nil, nil, nil, nil)
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WithArgs("TEST").WillReturnRows(rows)
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	It("should ignore quotes and actions after the as-of date", func() {
+		prices, err := eod.AdjustAssetEodPriceAsOf(ctx, mock, "TEST", day(3), nil)
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(3))
+		Expect(prices[0].EventDate).To(Equal(day(3)))
+		Expect(prices[0].AdjClose).To(Equal(20.0))
+		Expect(prices[2].CumSplitFactor).To(Equal(1.0))
+		Expect(prices[2].AdjClose).To(BeNumerically("~", 20.0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should match the current adjustment when as-of is after every quote", func() {
+		prices, err := eod.AdjustAssetEodPriceAsOf(ctx, mock, "TEST", day(10), nil)
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(4))
+		Expect(prices[3].CumSplitFactor).To(Equal(2.0))
+		Expect(prices[3].AdjClose).To(BeNumerically("~", 10.0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
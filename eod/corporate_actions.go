@@ -0,0 +1,194 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrUnknownActionSource      = errors.New("unknown corporate action source")
+	ErrMissingDistributionPrice = errors.New("no price for distributed asset on ex-date")
+)
+
+// ActionSource selects where dividends and splits are read from when
+// adjusting prices
+type ActionSource string
+
+const (
+	// EodActions reads the dividend and split_factor columns of eod
+	EodActions ActionSource = "eod"
+
+	// TableActions reads the corporate_actions table and ignores the
+	// actions stored in eod
+	TableActions ActionSource = "table"
+)
+
+// ParseActionSource converts a source name into an ActionSource
+func ParseActionSource(name string) (ActionSource, error) {
+	switch ActionSource(name) {
+	case EodActions, TableActions:
+		return ActionSource(name), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownActionSource, name)
+	}
+}
+
+// Distribution is a non-cash distribution of shares of another asset, such as
+// a spin-off
+type Distribution struct {
+	// CompositeFigi of the distributed asset
+	CompositeFigi string
+
+	// Ratio is the number of distributed shares per share held
+	Ratio float64
+
+	// Price of the distributed asset on the ex-date
+	Price float64
+}
+
+// Value returns the value of the distribution per share held
+func (dist *Distribution) Value() float64 {
+	return dist.Ratio * dist.Price
+}
+
+// RightsOffering gives holders the right to buy Ratio new shares per share
+// held at SubscriptionPrice
+type RightsOffering struct {
+	Ratio             float64
+	SubscriptionPrice float64
+}
+
+// TERP returns the theoretical ex-rights price given the cum-rights price
+func (rights *RightsOffering) TERP(cumPrice float64) float64 {
+	return (cumPrice + rights.Ratio*rights.SubscriptionPrice) / (1 + rights.Ratio)
+}
+
+// Factor returns the CRSP price factor of the offering, cumPrice / TERP.
+// Rights that are not in the money are worthless and have a factor of 1.
+func (rights *RightsOffering) Factor(cumPrice float64) float64 {
+	if rights.SubscriptionPrice >= cumPrice {
+		return 1
+	}
+	return cumPrice / rights.TERP(cumPrice)
+}
+
+// Value returns the theoretical value of the rights per share held
+func (rights *RightsOffering) Value(cumPrice float64) float64 {
+	if rights.SubscriptionPrice >= cumPrice {
+		return 0
+	}
+	return cumPrice - rights.TERP(cumPrice)
+}
+
+// DailyActions is the combined effect of every corporate action that goes ex
+// on a single date
+type DailyActions struct {
+	ExDate        time.Time
+	Dividends     map[DividendType]float64
+	Distributions []*Distribution
+	Rights        []*RightsOffering
+	SplitFactor   float64
+}
+
+// LoadActionsFromTable reads an asset's corporate actions from the
+// corporate_actions table keyed by ex-date (YYYY-MM-DD). Dividends of the
+// same type on the same date are summed; splits and stock dividends are
+// multiplied into a single split factor. Spin-offs
+// without a valuation in amount are valued at the distributed asset's close
+// on the ex-date.
+func LoadActionsFromTable(ctx context.Context, conn PgxIface, compositeFigi string) (map[string]*DailyActions, error) {
+	actions := make(map[string]*DailyActions)
+
+	rows, err := conn.Query(ctx, `SELECT a.action_type, a.ex_date, a.amount, a.ratio, a.dividend_type, a.distributed_figi, d.close FROM corporate_actions a LEFT JOIN LATERAL (SELECT close FROM eod WHERE composite_figi = a.distributed_figi AND event_date = a.ex_date LIMIT 1) d ON true WHERE a.composite_figi = $1 ORDER BY a.ex_date`, compositeFigi)
+	if err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not query corporate actions")
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var actionType string
+		var exDate time.Time
+		var amount, ratio, distributedClose pgtype.Float8
+		var dividendType, distributedFigi pgtype.Text
+		if err := rows.Scan(&actionType, &exDate, &amount, &ratio, &dividendType, &distributedFigi, &distributedClose); err != nil {
+			log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not scan corporate action")
+			return nil, err
+		}
+
+		key := exDate.Format("2006-01-02")
+		day, ok := actions[key]
+		if !ok {
+			day = &DailyActions{ExDate: exDate, Dividends: make(map[DividendType]float64), SplitFactor: 1}
+			actions[key] = day
+		}
+
+		switch actionType {
+		case ActionDividend:
+			divType := RegularDividend
+			if dividendType.Status == pgtype.Present {
+				if divType, err = ParseDividendType(dividendType.String); err != nil {
+					log.Error().Err(err).Str("CompositeFigi", compositeFigi).Time("ExDate", exDate).Msg("invalid dividend type")
+					return nil, err
+				}
+			}
+			day.Dividends[divType] += amount.Float
+		case ActionSplit:
+			if ratio.Status != pgtype.Present || ratio.Float <= 0 {
+				log.Error().Str("CompositeFigi", compositeFigi).Time("ExDate", exDate).Float64("Ratio", ratio.Float).Msg("split has a zero or negative ratio")
+				return nil, fmt.Errorf("%w: split of %s on %s has ratio %g", ErrInvalidAdjustmentFactor, compositeFigi, key, ratio.Float)
+			}
+			day.SplitFactor *= ratio.Float
+		case ActionStockDividend:
+			// a stock dividend of ratio new shares per share held is a
+			// 1+ratio:1 split
+			day.SplitFactor *= 1 + ratio.Float
+		case ActionRights:
+			day.Rights = append(day.Rights, &RightsOffering{
+				Ratio:             ratio.Float,
+				SubscriptionPrice: amount.Float,
+			})
+		case ActionSpinOff:
+			price := amount
+			if price.Status != pgtype.Present {
+				price = distributedClose
+			}
+			if price.Status != pgtype.Present {
+				log.Error().Str("CompositeFigi", compositeFigi).Str("DistributedFigi", distributedFigi.String).Time("ExDate", exDate).Msg("spin-off has no valuation")
+				return nil, fmt.Errorf("%w: %s on %s", ErrMissingDistributionPrice, distributedFigi.String, key)
+			}
+			day.Distributions = append(day.Distributions, &Distribution{
+				CompositeFigi: distributedFigi.String,
+				Ratio:         ratio.Float,
+				Price:         price.Float,
+			})
+		default:
+			log.Warn().Str("CompositeFigi", compositeFigi).Time("ExDate", exDate).Str("ActionType", actionType).Msg("ignoring unknown corporate action type")
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not read corporate actions")
+		return nil, err
+	}
+
+	return actions, nil
+}
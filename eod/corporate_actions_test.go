@@ -0,0 +1,277 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("corporate actions table", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+	)
+
+	actionColumns := []string{"action_type", "ex_date", "amount", "ratio", "dividend_type", "distributed_figi", "close"}
+
+	day := func(d int) time.Time {
+		return time.Date(2021, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	It("should reject unknown sources", func() {
+		_, err := eod.ParseActionSource("vendor")
+		Expect(err).To(MatchError(eod.ErrUnknownActionSource))
+	})
+
+	It("should adjust with actions from corporate_actions instead of eod", func() {
+		// the eod columns hold a stale dividend on day 2 that must be ignored
+		mock.ExpectQuery("^SELECT (.+) FROM corporate_actions a (.+) WHERE a.composite_figi = (.+) ORDER BY a.ex_date$").
+			WithArgs("TEST").
+			WillReturnRows(mock.NewRows(actionColumns).
+				AddRow(eod.ActionSplit, day(4), nil, 2.0, nil, nil, nil).
+				AddRow(eod.ActionDividend, day(3), 0.5, nil, "regular", nil, nil).
+				AddRow(eod.ActionDividend, day(3), 0.5, nil, "regular", nil, nil).
+				AddRow(eod.ActionDividend, day(9), 1.0, nil, "regular", nil, nil))
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").
+			WithArgs("TEST").
+			WillReturnRows(mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+				AddRow(day(4), "TEST", "TEST", 10.0, 0.0, 1.0, nil, nil, nil, nil).
+				AddRow(day(3), "TEST", "TEST", 20.0, 0.0, 1.0, nil, nil, nil, nil).
+				AddRow(day(2), "TEST", "TEST", 21.0, 5.0, 1.0, nil, nil, nil, nil))
+
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{ActionSource: eod.TableActions})
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(3))
+
+		Expect(prices[0].SplitFactor).To(Equal(2.0))
+		Expect(prices[1].Dividend).To(Equal(1.0))
+		Expect(prices[1].Dividends).To(Equal(map[eod.DividendType]float64{eod.RegularDividend: 1.0}))
+		Expect(prices[1].CumSplitFactor).To(Equal(2.0))
+		Expect(prices[2].Dividend).To(Equal(0.0))
+		Expect(prices[2].CumSplitFactor).To(Equal(2.0))
+		Expect(prices[2].CumDividendFactor).To(Equal(1.05))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should fail on a split with a zero ratio", func() {
+		mock.ExpectQuery("^SELECT (.+) FROM corporate_actions a").
+			WithArgs("TEST").
+			WillReturnRows(mock.NewRows(actionColumns).
+				AddRow(eod.ActionSplit, day(4), nil, 0.0, nil, nil, nil))
+
+		_, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{ActionSource: eod.TableActions})
+		Expect(err).To(MatchError(eod.ErrInvalidAdjustmentFactor))
+		Expect(err.Error()).To(ContainSubstring("split of TEST on 2021-01-04"))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	Describe("dividend types", func() {
+		expectActions := func(divType eod.DividendType) {
+			mock.ExpectQuery("^SELECT (.+) FROM corporate_actions a").
+				WithArgs("TEST").
+				WillReturnRows(mock.NewRows(actionColumns).
+					AddRow(eod.ActionDividend, day(2), 0.25, nil, "regular", nil, nil).
+					AddRow(eod.ActionDividend, day(2), 1.0, nil, string(divType), nil, nil))
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").
+				WithArgs("TEST").
+				WillReturnRows(mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+					AddRow(day(2), "TEST", "TEST", 20.0, 0.0, 1.0, nil, nil, nil, nil).
+					AddRow(day(1), "TEST", "TEST", 21.0, 0.0, 1.0, nil, nil, nil, nil))
+		}
+
+		DescribeTable("should include each type by default",
+			func(divType eod.DividendType) {
+				expectActions(divType)
+
+				prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{ActionSource: eod.TableActions})
+				Expect(err).To(BeNil())
+				Expect(prices[0].Dividends[divType]).To(Equal(1.0))
+				Expect(prices[0].Dividend).To(Equal(1.25))
+				Expect(prices[1].CumDividendFactor).To(Equal(1.0625))
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			},
+			Entry("special", eod.SpecialDividend),
+			Entry("capital gains", eod.CapitalGainsDividend),
+			Entry("return of capital", eod.ReturnOfCapital),
+		)
+
+		DescribeTable("should leave excluded types out of the adjustment",
+			func(divType eod.DividendType, cumDividendFactor float64) {
+				expectActions(divType)
+
+				prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{
+					ActionSource:      eod.TableActions,
+					ExcludedDividends: []eod.DividendType{divType},
+				})
+				Expect(err).To(BeNil())
+				Expect(prices[1].CumDividendFactor).To(Equal(cumDividendFactor))
+				Expect(prices[1].AdjClose).To(BeNumerically("~", 21.0/cumDividendFactor))
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			},
+			// both payments are regular when divType is regular
+			Entry("regular", eod.RegularDividend, 1.0),
+			Entry("special", eod.SpecialDividend, 1.0125),
+			Entry("capital gains", eod.CapitalGainsDividend, 1.0125),
+			Entry("return of capital", eod.ReturnOfCapital, 1.0125),
+		)
+
+		It("should reject unknown dividend types", func() {
+			_, err := eod.ParseDividendType("stock")
+			Expect(err).To(MatchError(eod.ErrUnknownDividendType))
+		})
+	})
+
+	Describe("spin-offs", func() {
+		expectEod := func() {
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").
+				WithArgs("PARENT").
+				WillReturnRows(mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+					AddRow(day(2), "PRNT", "PARENT", 30.0, 0.0, 1.0, nil, nil, nil, nil).
+					AddRow(day(1), "PRNT", "PARENT", 40.0, 0.0, 1.0, nil, nil, nil, nil))
+		}
+
+		It("should value the distribution at the spun-off asset's close", func() {
+			// 1 share of CHILD for every 2 shares of PARENT; CHILD closes at
+			// 20 on the ex-date so each PARENT share receives 10
+			mock.ExpectQuery("^SELECT (.+) FROM corporate_actions a").
+				WithArgs("PARENT").
+				WillReturnRows(mock.NewRows(actionColumns).
+					AddRow(eod.ActionSpinOff, day(2), nil, 0.5, nil, "CHILD", 20.0))
+			expectEod()
+
+			prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "PARENT", &eod.AdjustOptions{ActionSource: eod.TableActions})
+			Expect(err).To(BeNil())
+			Expect(prices[0].Distribution).To(Equal(10.0))
+			Expect(prices[0].Distributions).To(HaveLen(1))
+			Expect(prices[0].Distributions[0].CompositeFigi).To(Equal("CHILD"))
+			// the spin-off is a price factor so the split adjusted close
+			// has no cliff either
+			Expect(prices[1].CumDividendFactor).To(Equal(1.0))
+			Expect(prices[1].CumSplitFactor).To(BeNumerically("~", 4.0/3.0))
+			Expect(prices[1].AdjClose).To(BeNumerically("~", 30.0))
+			Expect(prices[1].SplitAdjClose).To(BeNumerically("~", 30.0))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should prefer an explicit valuation", func() {
+			mock.ExpectQuery("^SELECT (.+) FROM corporate_actions a").
+				WithArgs("PARENT").
+				WillReturnRows(mock.NewRows(actionColumns).
+					AddRow(eod.ActionSpinOff, day(2), 12.0, 0.5, nil, "CHILD", 20.0))
+			expectEod()
+
+			prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "PARENT", &eod.AdjustOptions{ActionSource: eod.TableActions})
+			Expect(err).To(BeNil())
+			Expect(prices[0].Distribution).To(Equal(6.0))
+			Expect(prices[1].CumSplitFactor).To(BeNumerically("~", 1.2))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should fail when the spun-off asset has no price", func() {
+			mock.ExpectQuery("^SELECT (.+) FROM corporate_actions a").
+				WithArgs("PARENT").
+				WillReturnRows(mock.NewRows(actionColumns).
+					AddRow(eod.ActionSpinOff, day(2), nil, 0.5, nil, "CHILD", nil))
+
+			_, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "PARENT", &eod.AdjustOptions{ActionSource: eod.TableActions})
+			Expect(err).To(MatchError(eod.ErrMissingDistributionPrice))
+		})
+	})
+
+	Describe("stock dividends and rights offerings", func() {
+		expectActions := func(rows *pgxmock.Rows) {
+			mock.ExpectQuery("^SELECT (.+) FROM corporate_actions a").
+				WithArgs("TEST").
+				WillReturnRows(rows)
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").
+				WithArgs("TEST").
+				WillReturnRows(mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+					AddRow(day(3), "TEST", "TEST", 9.5, 0.0, 1.0, nil, nil, nil, nil).
+					AddRow(day(2), "TEST", "TEST", 9.6, 0.0, 1.0, nil, nil, nil, nil).
+					AddRow(day(1), "TEST", "TEST", 10.0, 0.0, 1.0, nil, nil, nil, nil))
+		}
+
+		It("should treat a 5% stock dividend as a 1.05:1 split", func() {
+			expectActions(mock.NewRows(actionColumns).
+				AddRow(eod.ActionStockDividend, day(2), nil, 0.05, nil, nil, nil))
+
+			prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{ActionSource: eod.TableActions})
+			Expect(err).To(BeNil())
+			Expect(prices[1].SplitFactor).To(Equal(1.05))
+			Expect(prices[2].CumSplitFactor).To(Equal(1.05))
+			Expect(prices[2].CumDividendFactor).To(Equal(1.0))
+			Expect(prices[2].AdjClose).To(BeNumerically("~", 10.0/1.05))
+			Expect(prices[2].SplitAdjClose).To(BeNumerically("~", 10.0/1.05))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should adjust for rights using the theoretical ex-rights price", func() {
+			// 1 new share for every 4 held at 8 with a cum-rights close of
+			// 10: TERP = (10 + 0.25 * 8) / 1.25 = 9.6 and the factor is
+			// 10 / 9.6, so the cum-rights close adjusts to the TERP
+			expectActions(mock.NewRows(actionColumns).
+				AddRow(eod.ActionRights, day(2), 8.0, 0.25, nil, nil, nil))
+
+			prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{ActionSource: eod.TableActions})
+			Expect(err).To(BeNil())
+			Expect(prices[1].CumDividendFactor).To(Equal(1.0))
+			Expect(prices[1].Distribution).To(BeNumerically("~", 0.4))
+			// rights are a price factor so the split adjusted close and
+			// volume are adjusted too
+			Expect(prices[2].CumDividendFactor).To(Equal(1.0))
+			Expect(prices[2].CumSplitFactor).To(BeNumerically("~", 10.0/9.6))
+			Expect(prices[2].AdjClose).To(BeNumerically("~", 9.6))
+			Expect(prices[2].SplitAdjClose).To(BeNumerically("~", 9.6))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should ignore rights that are not in the money", func() {
+			expectActions(mock.NewRows(actionColumns).
+				AddRow(eod.ActionRights, day(2), 12.0, 0.25, nil, nil, nil))
+
+			prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{ActionSource: eod.TableActions})
+			Expect(err).To(BeNil())
+			Expect(prices[1].Distribution).To(Equal(0.0))
+			Expect(prices[2].CumDividendFactor).To(Equal(1.0))
+			Expect(prices[2].AdjClose).To(Equal(10.0))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should calculate the theoretical ex-rights price", func() {
+			// 2 new shares for every 5 held at 15 with a cum-rights price of 22
+			rights := &eod.RightsOffering{Ratio: 0.4, SubscriptionPrice: 15}
+			Expect(rights.TERP(22)).To(BeNumerically("~", 20.0))
+			Expect(rights.Factor(22)).To(BeNumerically("~", 1.1))
+			Expect(rights.Value(22)).To(BeNumerically("~", 2.0))
+		})
+	})
+})
@@ -0,0 +1,149 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrUnknownDelistingMode = errors.New("unknown delisting mode")
+	ErrInvalidDelisting     = errors.New("delisting has neither a return nor a final payment")
+)
+
+// DelistingMode controls how a delisting return is added to the adjusted
+// history
+type DelistingMode string
+
+const (
+	// DelistingIgnore leaves the history unchanged
+	DelistingIgnore DelistingMode = "ignore"
+
+	// DelistingFold compounds the delisting return into the adjusted close
+	// of the final quote, so the last day's return is (1+ret)(1+dlret)-1 as
+	// in CRSP's combined return
+	DelistingFold DelistingMode = "fold"
+
+	// DelistingRow appends a synthetic quote valued at the delisting
+	// proceeds after the final quote. Synthetic quotes are returned in the
+	// history but are not written to eod.
+	DelistingRow DelistingMode = "row"
+)
+
+// ParseDelistingMode converts a mode name into a DelistingMode
+func ParseDelistingMode(name string) (DelistingMode, error) {
+	switch DelistingMode(name) {
+	case DelistingIgnore, DelistingFold, DelistingRow:
+		return DelistingMode(name), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownDelistingMode, name)
+	}
+}
+
+// Delisting records when an asset stopped trading and what holders received.
+// Following CRSP, Return is measured from the last traded close to the value
+// of the proceeds; when it is not known it is derived from FinalPayment.
+type Delisting struct {
+	CompositeFigi string
+	DelistingDate time.Time
+	PaymentDate   pgtype.Date
+	Return        pgtype.Float8
+	FinalPayment  pgtype.Float8
+}
+
+// DelistingReturn returns the delisting return relative to lastClose
+func (delisting *Delisting) DelistingReturn(lastClose float64) (float64, error) {
+	if delisting.Return.Status == pgtype.Present {
+		return delisting.Return.Float, nil
+	}
+	if delisting.FinalPayment.Status == pgtype.Present && lastClose > 0 {
+		return delisting.FinalPayment.Float/lastClose - 1, nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrInvalidDelisting, delisting.CompositeFigi)
+}
+
+// LoadDelisting returns the delisting of an asset or nil if it has not been
+// delisted
+func LoadDelisting(ctx context.Context, conn PgxIface, compositeFigi string) (*Delisting, error) {
+	delisting := &Delisting{CompositeFigi: compositeFigi}
+	err := conn.QueryRow(ctx, `SELECT delisting_date, payment_date, delisting_return, final_payment FROM delistings WHERE composite_figi = $1`, compositeFigi).
+		Scan(&delisting.DelistingDate, &delisting.PaymentDate, &delisting.Return, &delisting.FinalPayment)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not load delisting")
+		return nil, err
+	}
+	return delisting, nil
+}
+
+// applyDelisting adds the delisting return to history, which is sorted newest
+// first and already adjusted
+func applyDelisting(history []*Eod, delisting *Delisting, mode DelistingMode) ([]*Eod, error) {
+	if len(history) == 0 || mode == DelistingIgnore {
+		return history, nil
+	}
+
+	final := history[0]
+	if final.EventDate.After(delisting.DelistingDate) {
+		log.Warn().Str("CompositeFigi", delisting.CompositeFigi).Time("DelistingDate", delisting.DelistingDate).Time("LastQuote", final.EventDate).Msg("asset has quotes after its delisting date")
+	}
+
+	dlret, err := delisting.DelistingReturn(final.Close)
+	if err != nil {
+		log.Error().Err(err).Msg("could not calculate delisting return")
+		return history, err
+	}
+
+	if mode == DelistingFold {
+		final.AdjClose *= 1 + dlret
+		final.NetAdjClose *= 1 + dlret
+		return history, nil
+	}
+
+	eventDate := final.EventDate.AddDate(0, 0, 1)
+	switch {
+	case delisting.PaymentDate.Status == pgtype.Present && delisting.PaymentDate.Time.After(final.EventDate):
+		eventDate = delisting.PaymentDate.Time
+	case delisting.DelistingDate.After(final.EventDate):
+		eventDate = delisting.DelistingDate
+	}
+
+	proceeds := final.Close * (1 + dlret)
+	synthetic := &Eod{
+		EventDate:            eventDate,
+		Ticker:               final.Ticker,
+		CompositeFigi:        final.CompositeFigi,
+		Close:                proceeds,
+		AdjClose:             final.AdjClose * (1 + dlret),
+		NetAdjClose:          final.NetAdjClose * (1 + dlret),
+		SplitAdjClose:        proceeds,
+		SplitFactor:          1,
+		CumSplitFactor:       1,
+		CumDividendFactor:    1,
+		CumNetDividendFactor: 1,
+		Synthetic:            true,
+	}
+
+	return append([]*Eod{synthetic}, history...), nil
+}
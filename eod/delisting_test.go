@@ -0,0 +1,136 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("delisting returns", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+	)
+
+	day := func(d int) time.Time {
+		return time.Date(2021, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	delistingColumns := []string{"delisting_date", "payment_date", "delisting_return", "final_payment"}
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+
+		// 2:1 split on day 2 and a final quote on day 3
+		rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+			AddRow(day(3), "TEST", "TEST", 10.0, 0.0, 1.0, nil, nil, nil, nil).
+			AddRow(day(2), "TEST", "TEST", 11.0, 0.0, 2.0, nil, nil, nil, nil).
+			AddRow(day(1), "TEST", "TEST", 20.0, 0.0, 1.0, nil, nil, nil, nil)
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WithArgs("TEST").WillReturnRows(rows)
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	It("should fold the delisting return into the final adjusted close", func() {
+		mock.ExpectQuery("^SELECT (.+) FROM delistings WHERE composite_figi = (.+)$").
+			WithArgs("TEST").
+			WillReturnRows(mock.NewRows(delistingColumns).AddRow(day(3), nil, -0.3, nil))
+
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{Delisting: eod.DelistingFold})
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(3))
+		Expect(prices[0].Close).To(Equal(10.0))
+		Expect(prices[0].AdjClose).To(BeNumerically("~", 7.0))
+		Expect(prices[1].AdjClose).To(Equal(11.0))
+		Expect(prices[2].AdjClose).To(Equal(10.0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should derive the return from the final payment", func() {
+		mock.ExpectQuery("^SELECT (.+) FROM delistings").
+			WithArgs("TEST").
+			WillReturnRows(mock.NewRows(delistingColumns).AddRow(day(3), nil, nil, 12.5))
+
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{Delisting: eod.DelistingFold})
+		Expect(err).To(BeNil())
+		Expect(prices[0].AdjClose).To(BeNumerically("~", 12.5))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should append a synthetic final row", func() {
+		mock.ExpectQuery("^SELECT (.+) FROM delistings").
+			WithArgs("TEST").
+			WillReturnRows(mock.NewRows(delistingColumns).AddRow(day(3), pgtype.Date{Time: day(8), Status: pgtype.Present}, -0.3, nil))
+
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{Delisting: eod.DelistingRow})
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(4))
+		Expect(prices[0].Synthetic).To(BeTrue())
+		Expect(prices[0].EventDate).To(Equal(day(8)))
+		Expect(prices[0].AdjClose).To(BeNumerically("~", 7.0))
+		Expect(prices[1].Synthetic).To(BeFalse())
+		Expect(prices[1].AdjClose).To(Equal(10.0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should not save synthetic rows", func() {
+		mock.ExpectQuery("^SELECT (.+) FROM delistings").
+			WithArgs("TEST").
+			WillReturnRows(mock.NewRows(delistingColumns).AddRow(day(3), nil, -0.3, nil))
+
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{Delisting: eod.DelistingRow})
+		Expect(err).To(BeNil())
+		Expect(prices[0].EventDate).To(Equal(day(4)))
+
+		mock.ExpectBegin()
+		for _, myEod := range prices[1:] {
+			mock.ExpectExec("^UPDATE eod SET adj_close").
+				WithArgs(myEod.AdjClose, null, null, null, null, "TEST", myEod.EventDate).
+				WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+		}
+		mock.ExpectQuery("^DELETE FROM eod_adjustment_factors").WithArgs("TEST").WillReturnRows(mock.NewRows([]string{"event_date", "split_factor", "dividend_factor"}))
+		mock.ExpectCopyFrom(`"eod_adjustment_factors"`, []string{"composite_figi", "event_date", "split_factor", "dividend_factor"}).WillReturnResult(3)
+		mock.ExpectExec("^INSERT INTO eod_adj_close_history").WithArgs("TEST", pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		mock.ExpectCommit()
+
+		Expect(eod.SaveAdjCloseToDb(ctx, mock, prices)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should leave assets that were not delisted unchanged", func() {
+		mock.ExpectQuery("^SELECT (.+) FROM delistings").
+			WithArgs("TEST").
+			WillReturnError(pgx.ErrNoRows)
+
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{Delisting: eod.DelistingFold})
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(3))
+		Expect(prices[0].AdjClose).To(Equal(10.0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
@@ -0,0 +1,244 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+// AdjCloseDiff summarizes how newly calculated adjusted prices differ from
+// those stored in the database. A row is changed if any column of the
+// compared series is. MaxAbsChange only covers price columns since volumes
+// are in different units; MaxRelChange covers every column.
+type AdjCloseDiff struct {
+	CompositeFigi string
+	Rows          int
+	RowsChanged   int
+	MaxAbsChange  float64
+	MaxRelChange  float64
+	FirstChanged  time.Time
+	Changes       []*AdjCloseChange
+}
+
+// AdjCloseChange is a single column of a row whose calculated value differs
+// from the stored value; Stored or Calculated are NULL if there is no value
+type AdjCloseChange struct {
+	EventDate  time.Time
+	Column     string
+	Stored     pgtype.Float8
+	Calculated pgtype.Float8
+}
+
+// Tolerance bounds the differences a diff ignores. A calculated value is
+// unchanged if it is within Abs of the stored value or, when Rel is set,
+// within Rel times the magnitude of the stored value.
+type Tolerance struct {
+	Abs float64
+	Rel float64
+}
+
+// Within returns true if calculated is within the tolerance of stored
+func (tolerance Tolerance) Within(stored, calculated float64) bool {
+	absChange := math.Abs(calculated - stored)
+	return absChange <= tolerance.Abs || (tolerance.Rel > 0 && absChange <= tolerance.Rel*math.Abs(stored))
+}
+
+// Changed returns true if any row differs from the stored value
+func (diff *AdjCloseDiff) Changed() bool {
+	return diff.RowsChanged > 0
+}
+
+// LoadStoredAdjClose reads the adjusted prices currently stored for an asset
+// keyed by event date (YYYY-MM-DD) and column for each series (adj_close
+// and the rest of the total return series when none are given)
+func LoadStoredAdjClose(ctx context.Context, conn PgxIface, compositeFigi string, series ...Series) (map[string]map[string]pgtype.Float8, error) {
+	stored := make(map[string]map[string]pgtype.Float8)
+	columns := seriesColumns(series)
+
+	selects := make([]string, len(columns))
+	for idx, col := range columns {
+		selects[idx] = col + "::double precision"
+	}
+
+	rows, err := conn.Query(ctx, fmt.Sprintf("SELECT event_date, %s FROM eod WHERE composite_figi = $1", strings.Join(selects, ", ")), compositeFigi)
+	if err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not query stored adjusted close")
+		return stored, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var eventDate time.Time
+		values := make([]pgtype.Float8, len(columns))
+		dest := []interface{}{&eventDate}
+		for idx := range values {
+			dest = append(dest, &values[idx])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not scan stored adjusted close")
+			return stored, err
+		}
+
+		row := make(map[string]pgtype.Float8, len(columns))
+		for idx, col := range columns {
+			row[col] = values[idx]
+		}
+		stored[eventDate.Format("2006-01-02")] = row
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not read stored adjusted close")
+		return stored, err
+	}
+
+	return stored, nil
+}
+
+// seriesColumns returns the eod columns of every series, defaulting to the
+// total return series
+func seriesColumns(series []Series) []string {
+	if len(series) == 0 {
+		series = []Series{TotalReturnSeries}
+	}
+	columns := make([]string, 0)
+	for _, s := range series {
+		columns = append(columns, s.Columns()...)
+	}
+	return columns
+}
+
+// DiffAdjClose compares every column of the series (the total return series
+// when none are given) of the calculated prices against stored values.
+// Differences within tolerance are ignored. Values that are NULL on only one
+// side are counted as changed but do not contribute to the maximum change.
+func DiffAdjClose(compositeFigi string, stored map[string]map[string]pgtype.Float8, prices []*Eod, tolerance Tolerance, series ...Series) *AdjCloseDiff {
+	diff := &AdjCloseDiff{
+		CompositeFigi: compositeFigi,
+	}
+
+	if len(series) == 0 {
+		series = []Series{TotalReturnSeries}
+	}
+
+	for _, myEod := range prices {
+		// synthetic quotes are never stored
+		if myEod.Synthetic {
+			continue
+		}
+		diff.Rows++
+
+		storedRow := stored[myEod.EventDate.Format("2006-01-02")]
+		rowChanged := false
+		for _, s := range series {
+			for idx, val := range s.Values(myEod) {
+				col := s.Columns()[idx]
+				calculated := toFloat8(val)
+				old, ok := storedRow[col]
+				if !ok {
+					old = pgtype.Float8{Status: pgtype.Null}
+				}
+
+				if !float8Changed(old, calculated, tolerance) {
+					continue
+				}
+
+				if old.Status == pgtype.Present && calculated.Status == pgtype.Present {
+					absChange := math.Abs(calculated.Float - old.Float)
+					if col != "adj_volume" {
+						diff.MaxAbsChange = math.Max(diff.MaxAbsChange, absChange)
+					}
+					if old.Float != 0 {
+						diff.MaxRelChange = math.Max(diff.MaxRelChange, absChange/math.Abs(old.Float))
+					}
+				}
+
+				rowChanged = true
+				diff.Changes = append(diff.Changes, &AdjCloseChange{
+					EventDate:  myEod.EventDate,
+					Column:     col,
+					Stored:     old,
+					Calculated: calculated,
+				})
+			}
+		}
+
+		if !rowChanged {
+			continue
+		}
+		diff.RowsChanged++
+		if diff.FirstChanged.IsZero() || myEod.EventDate.Before(diff.FirstChanged) {
+			diff.FirstChanged = myEod.EventDate
+		}
+	}
+
+	return diff
+}
+
+// float8Changed returns true if calculated differs from old by more than
+// tolerance or only one of them is NULL
+func float8Changed(old, calculated pgtype.Float8, tolerance Tolerance) bool {
+	oldPresent := old.Status == pgtype.Present
+	calculatedPresent := calculated.Status == pgtype.Present
+	if oldPresent != calculatedPresent {
+		return true
+	}
+	if !oldPresent {
+		return false
+	}
+	return !tolerance.Within(old.Float, calculated.Float)
+}
+
+// toFloat8 converts a value returned by Series.Values to a pgtype.Float8
+func toFloat8(val interface{}) pgtype.Float8 {
+	switch v := val.(type) {
+	case float64:
+		return pgtype.Float8{Float: v, Status: pgtype.Present}
+	case pgtype.Float8:
+		return nullIfUndefined(v)
+	default:
+		return pgtype.Float8{Status: pgtype.Null}
+	}
+}
+
+// DiffAssetAdjClose compares calculated prices for an asset against the
+// values stored in the database
+func DiffAssetAdjClose(ctx context.Context, conn PgxIface, compositeFigi string, prices []*Eod, tolerance Tolerance, series ...Series) (*AdjCloseDiff, error) {
+	stored, err := LoadStoredAdjClose(ctx, conn, compositeFigi, series...)
+	if err != nil {
+		return nil, err
+	}
+	return DiffAdjClose(compositeFigi, stored, prices, tolerance, series...), nil
+}
+
+// PrintAdjCloseDiffs writes a table of assets with changed prices to w
+func PrintAdjCloseDiffs(w io.Writer, diffs []*AdjCloseDiff) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CompositeFigi\tRows\tChanged\tMaxAbsChange\tMaxRelChange\tFirstChanged")
+	for _, diff := range diffs {
+		if !diff.Changed() {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.6f\t%.4f%%\t%s\n", diff.CompositeFigi, diff.Rows, diff.RowsChanged, diff.MaxAbsChange, diff.MaxRelChange*100, diff.FirstChanged.Format("2006-01-02"))
+	}
+	tw.Flush()
+}
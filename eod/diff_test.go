@@ -0,0 +1,154 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/jackc/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("diff adjusted close prices", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+		day1 time.Time
+		day2 time.Time
+		day3 time.Time
+
+		storedColumns = []string{"event_date", "adj_close", "adj_open", "adj_high", "adj_low", "adj_volume"}
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+
+		day1 = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		day2 = time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+		day3 = time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)
+
+		// $1 dividend on day 3 -> day 1 and 2 adjust to 10 / 1.1
+		rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+			AddRow(day3, "TEST", "TEST", 10.0, 1.0, 1.0, nil, nil, nil, nil).
+			AddRow(day2, "TEST", "TEST", 10.0, 0.0, 1.0, nil, nil, nil, nil).
+			AddRow(day1, "TEST", "TEST", 10.0, 0.0, 1.0, nil, nil, nil, nil)
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY").WillReturnRows(rows)
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	It("should summarize changed rows without writing to the database", func() {
+		stored := mock.NewRows(storedColumns).
+			AddRow(day3, 10.0, nil, nil, nil, nil).
+			AddRow(day2, 10.0, nil, nil, nil, nil).
+			AddRow(day1, 10.0, nil, nil, nil, nil)
+		mock.ExpectQuery("^SELECT event_date, adj_close::double precision, adj_open::double precision, (.+) FROM eod WHERE composite_figi = (.+)$").WithArgs("TEST").WillReturnRows(stored)
+
+		diff, err := eod.AdjustAsset(ctx, mock, "TEST", &eod.AdjustOptions{DryRun: true})
+		Expect(err).To(BeNil())
+		Expect(diff.Rows).To(Equal(3))
+		Expect(diff.RowsChanged).To(Equal(2))
+		Expect(diff.MaxAbsChange).To(BeNumerically("~", 10-10/1.1, 1e-9))
+		Expect(diff.MaxRelChange).To(BeNumerically("~", (10-10/1.1)/10, 1e-9))
+		Expect(diff.FirstChanged).To(Equal(day1))
+
+		// no Begin/Exec expectations were registered so any write would fail
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+
+		buf := &bytes.Buffer{}
+		eod.PrintAdjCloseDiffs(buf, []*eod.AdjCloseDiff{diff})
+		Expect(buf.String()).To(ContainSubstring("2021-01-01"))
+	})
+
+	It("should hide changes within the tolerance", func() {
+		stored := mock.NewRows(storedColumns).
+			AddRow(day3, 10.0, nil, nil, nil, nil).
+			AddRow(day2, 10/1.1+1e-12, nil, nil, nil, nil).
+			AddRow(day1, nil, nil, nil, nil, nil)
+		mock.ExpectQuery("^SELECT event_date, (.+) FROM eod WHERE composite_figi = (.+)$").WithArgs("TEST").WillReturnRows(stored)
+
+		diff, err := eod.AdjustAsset(ctx, mock, "TEST", &eod.AdjustOptions{DryRun: true, Tolerance: eod.Tolerance{Abs: 1e-9}})
+		Expect(err).To(BeNil())
+		Expect(diff.RowsChanged).To(Equal(1))
+		Expect(diff.MaxAbsChange).To(Equal(0.0))
+		Expect(diff.FirstChanged).To(Equal(day1))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should hide changes within the relative tolerance", func() {
+		stored := mock.NewRows(storedColumns).
+			AddRow(day3, 10.0, nil, nil, nil, nil).
+			AddRow(day2, 10/1.1*(1+1e-10), nil, nil, nil, nil).
+			AddRow(day1, 10/1.1*(1+1e-6), nil, nil, nil, nil)
+		mock.ExpectQuery("^SELECT event_date, (.+) FROM eod WHERE composite_figi = (.+)$").WithArgs("TEST").WillReturnRows(stored)
+
+		diff, err := eod.AdjustAsset(ctx, mock, "TEST", &eod.AdjustOptions{DryRun: true, Tolerance: eod.Tolerance{Rel: 1e-9}})
+		Expect(err).To(BeNil())
+		Expect(diff.RowsChanged).To(Equal(1))
+		Expect(diff.FirstChanged).To(Equal(day1))
+		Expect(diff.MaxRelChange).To(BeNumerically("~", 1e-6, 1e-9))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should compare the columns of the selected series", func() {
+		stored := mock.NewRows([]string{"event_date", "split_adj_close"}).
+			AddRow(day3, 10.0).
+			AddRow(day2, 10.0).
+			AddRow(day1, 5.0)
+		mock.ExpectQuery("^SELECT event_date, split_adj_close::double precision FROM eod WHERE composite_figi = (.+)$").WithArgs("TEST").WillReturnRows(stored)
+
+		diff, err := eod.AdjustAsset(ctx, mock, "TEST", &eod.AdjustOptions{DryRun: true, Series: []eod.Series{eod.SplitSeries}})
+		Expect(err).To(BeNil())
+		Expect(diff.RowsChanged).To(Equal(1))
+		Expect(diff.Changes).To(HaveLen(1))
+		Expect(diff.Changes[0].Column).To(Equal("split_adj_close"))
+		Expect(diff.Changes[0].Calculated.Float).To(Equal(10.0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
+
+var _ = Describe("diff adjusted prices", func() {
+	day1 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	present := func(val float64) pgtype.Float8 {
+		return pgtype.Float8{Float: val, Status: pgtype.Present}
+	}
+
+	It("should detect changes to adjusted open, high, low and volume", func() {
+		prices := []*eod.Eod{
+			{CompositeFigi: "TEST", EventDate: day1, AdjClose: 10, AdjOpen: present(9), AdjHigh: present(11), AdjLow: present(8), AdjVolume: present(2000)},
+		}
+		stored := map[string]map[string]pgtype.Float8{
+			"2021-01-01": {"adj_close": present(10), "adj_open": present(9), "adj_high": present(11), "adj_low": present(8), "adj_volume": present(1000)},
+		}
+
+		diff := eod.DiffAdjClose("TEST", stored, prices, eod.Tolerance{})
+		Expect(diff.RowsChanged).To(Equal(1))
+		Expect(diff.Changes).To(HaveLen(1))
+		Expect(diff.Changes[0].Column).To(Equal("adj_volume"))
+		// volumes do not count towards the absolute change of prices
+		Expect(diff.MaxAbsChange).To(Equal(0.0))
+		Expect(diff.MaxRelChange).To(Equal(1.0))
+	})
+})
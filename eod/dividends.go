@@ -0,0 +1,87 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownDividendType = errors.New("unknown dividend type")
+)
+
+// DividendType classifies a cash distribution. Dividends read from the eod
+// table are not classified and are treated as RegularDividend.
+type DividendType string
+
+const (
+	RegularDividend      DividendType = "regular"
+	SpecialDividend      DividendType = "special"
+	CapitalGainsDividend DividendType = "capital_gains"
+	ReturnOfCapital      DividendType = "return_of_capital"
+)
+
+// DividendTypes lists every DividendType
+var DividendTypes = []DividendType{RegularDividend, SpecialDividend, CapitalGainsDividend, ReturnOfCapital}
+
+// ParseDividendType converts a type name into a DividendType
+func ParseDividendType(name string) (DividendType, error) {
+	for _, divType := range DividendTypes {
+		if string(divType) == name {
+			return divType, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownDividendType, name)
+}
+
+// TaxableDividendTypes are the dividend types withholding tax is taken from
+// in the net total return series; capital gains distributions and return of
+// capital are paid gross
+var TaxableDividendTypes = []DividendType{RegularDividend, SpecialDividend}
+
+// includedDividends returns the sum of the dividends whose type is not
+// excluded
+func includedDividends(dividends map[DividendType]float64, excluded []DividendType) float64 {
+	total := 0.0
+	for divType, amount := range dividends {
+		if !hasDividendType(excluded, divType) {
+			total += amount
+		}
+	}
+	return total
+}
+
+// taxableDividends returns the sum of the included dividends whose type is
+// one of TaxableDividendTypes
+func taxableDividends(dividends map[DividendType]float64, excluded []DividendType) float64 {
+	total := 0.0
+	for divType, amount := range dividends {
+		if hasDividendType(TaxableDividendTypes, divType) && !hasDividendType(excluded, divType) {
+			total += amount
+		}
+	}
+	return total
+}
+
+// hasDividendType returns true if divType is in types
+func hasDividendType(types []DividendType, divType DividendType) bool {
+	for _, item := range types {
+		if item == divType {
+			return true
+		}
+	}
+	return false
+}
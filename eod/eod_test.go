@@ -16,8 +16,10 @@ package eod_test
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/jackc/pgtype"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/pashagolub/pgxmock"
@@ -35,13 +37,13 @@ var _ = Describe("adjust close prices", func() {
 			nyc, err := time.LoadLocation("America/New_York")
 			Expect(err).To(BeNil())
 
-			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor"}).
-				AddRow(time.Date(2021, 1, 1, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0).
-				AddRow(time.Date(2021, 1, 2, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, .25, 1.0).
-				AddRow(time.Date(2021, 1, 3, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0).
-				AddRow(time.Date(2021, 1, 4, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0)
+			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+				AddRow(time.Date(2021, 1, 1, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 2, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, .25, 1.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 3, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 4, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil)
 
-			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY ticker, event_date DESC$").WillReturnRows(rows)
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WillReturnRows(rows)
 
 			prices, err := eod.AdjustAssetEodPrice(ctx, mock, "TEST")
 			Expect(err).To(BeNil())
@@ -55,6 +57,7 @@ var _ = Describe("adjust close prices", func() {
 
 			Expect(prices[2].EventDate).To(Equal(time.Date(2021, 1, 3, 16, 0, 0, 0, nyc)))
 			Expect(prices[2].AdjClose).To(Equal(.8))
+			Expect(prices[2].SplitAdjClose).To(Equal(1.0))
 		})
 	})
 
@@ -68,13 +71,13 @@ var _ = Describe("adjust close prices", func() {
 			nyc, err := time.LoadLocation("America/New_York")
 			Expect(err).To(BeNil())
 
-			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor"}).
-				AddRow(time.Date(2021, 1, 1, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0).
-				AddRow(time.Date(2021, 1, 2, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 2.0).
-				AddRow(time.Date(2021, 1, 3, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0).
-				AddRow(time.Date(2021, 1, 4, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0)
+			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+				AddRow(time.Date(2021, 1, 1, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 2, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 2.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 3, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 4, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil)
 
-			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY ticker, event_date DESC$").WillReturnRows(rows)
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WillReturnRows(rows)
 
 			prices, err := eod.AdjustAssetEodPrice(ctx, mock, "TEST")
 			Expect(err).To(BeNil())
@@ -88,6 +91,7 @@ var _ = Describe("adjust close prices", func() {
 
 			Expect(prices[2].EventDate).To(Equal(time.Date(2021, 1, 3, 16, 0, 0, 0, nyc)))
 			Expect(prices[2].AdjClose).To(Equal(.5))
+			Expect(prices[2].SplitAdjClose).To(Equal(.5))
 		})
 	})
 
@@ -101,13 +105,13 @@ var _ = Describe("adjust close prices", func() {
 			nyc, err := time.LoadLocation("America/New_York")
 			Expect(err).To(BeNil())
 
-			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor"}).
-				AddRow(time.Date(2021, 1, 1, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0).
-				AddRow(time.Date(2021, 1, 2, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 1.0, 2.0).
-				AddRow(time.Date(2021, 1, 3, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0).
-				AddRow(time.Date(2021, 1, 4, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0)
+			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+				AddRow(time.Date(2021, 1, 1, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 2, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 1.0, 2.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 3, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 4, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil)
 
-			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY ticker, event_date DESC$").WillReturnRows(rows)
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WillReturnRows(rows)
 
 			prices, err := eod.AdjustAssetEodPrice(ctx, mock, "TEST")
 			Expect(err).To(BeNil())
@@ -121,6 +125,61 @@ var _ = Describe("adjust close prices", func() {
 
 			Expect(prices[2].EventDate).To(Equal(time.Date(2021, 1, 3, 16, 0, 0, 0, nyc)))
 			Expect(prices[2].AdjClose).To(Equal(.25))
+			Expect(prices[2].SplitAdjClose).To(Equal(.5))
+			Expect(prices[2].CumSplitFactor).To(Equal(2.0))
+			Expect(prices[2].CumDividendFactor).To(Equal(2.0))
+		})
+	})
+
+	Context("with open, high, low and volume", func() {
+		It("should adjust prices for splits and dividends and volume for splits only", func() {
+			ctx := context.Background()
+			mock, err := pgxmock.NewConn()
+			Expect(err).To(BeNil())
+			defer mock.Close(ctx)
+
+			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+				AddRow(time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC), "TEST", "TEST", 2.0, 0.0, 1.0, 2.0, 2.5, 1.5, 100.0).
+				AddRow(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), "TEST", "TEST", 2.0, 2.0, 2.0, 2.0, 3.0, 1.0, 200.0).
+				AddRow(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), "TEST", "TEST", 4.0, 0.0, 1.0, nil, 8.0, 4.0, nil)
+
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WillReturnRows(rows)
+
+			prices, err := eod.AdjustAssetEodPrice(ctx, mock, "TEST")
+			Expect(err).To(BeNil())
+			Expect(prices).To(HaveLen(3))
+
+			Expect(prices[0].AdjOpen.Float).To(Equal(2.0))
+			Expect(prices[0].AdjHigh.Float).To(Equal(2.5))
+			Expect(prices[0].AdjLow.Float).To(Equal(1.5))
+			Expect(prices[0].AdjVolume.Float).To(Equal(100.0))
+
+			// split factor 2 and dividend factor (1 + 2/2) = 2
+			Expect(prices[2].AdjClose).To(Equal(1.0))
+			Expect(prices[2].AdjOpen.Status).To(Equal(pgtype.Null))
+			Expect(prices[2].AdjHigh.Float).To(Equal(2.0))
+			Expect(prices[2].AdjLow.Float).To(Equal(1.0))
+			Expect(prices[2].AdjVolume.Status).To(Equal(pgtype.Null))
+		})
+
+		It("should double volume across a 2:1 split", func() {
+			ctx := context.Background()
+			mock, err := pgxmock.NewConn()
+			Expect(err).To(BeNil())
+			defer mock.Close(ctx)
+
+			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+				AddRow(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), "TEST", "TEST", 1.0, 0.0, 2.0, 1.0, 1.0, 1.0, 200.0).
+				AddRow(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), "TEST", "TEST", 2.0, 0.0, 1.0, 2.0, 2.0, 2.0, 100.0)
+
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WillReturnRows(rows)
+
+			prices, err := eod.AdjustAssetEodPrice(ctx, mock, "TEST")
+			Expect(err).To(BeNil())
+			Expect(prices).To(HaveLen(2))
+			Expect(prices[1].AdjClose).To(Equal(1.0))
+			Expect(prices[1].AdjOpen.Float).To(Equal(1.0))
+			Expect(prices[1].AdjVolume.Float).To(Equal(200.0))
 		})
 	})
 
@@ -134,13 +193,13 @@ var _ = Describe("adjust close prices", func() {
 			nyc, err := time.LoadLocation("America/New_York")
 			Expect(err).To(BeNil())
 
-			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor"}).
-				AddRow(time.Date(2021, 1, 1, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0).
-				AddRow(time.Date(2021, 1, 2, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0).
-				AddRow(time.Date(2021, 1, 3, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0).
-				AddRow(time.Date(2021, 1, 4, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0)
+			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+				AddRow(time.Date(2021, 1, 1, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 2, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 3, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 4, 16, 0, 0, 0, nyc), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil)
 
-			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY ticker, event_date DESC$").WillReturnRows(rows)
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WillReturnRows(rows)
 
 			prices, err := eod.AdjustAssetEodPrice(ctx, mock, "TEST")
 			Expect(err).To(BeNil())
@@ -159,4 +218,135 @@ var _ = Describe("adjust close prices", func() {
 			Expect(prices[3].AdjClose).To(Equal(1.0))
 		})
 	})
+
+	Context("with a zero split factor", func() {
+		It("should fail the asset", func() {
+			ctx := context.Background()
+			mock, err := pgxmock.NewConn()
+			Expect(err).To(BeNil())
+			defer mock.Close(ctx)
+
+			rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+				AddRow(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), "TEST", "TEST", 1.0, 0.0, 0.0, nil, nil, nil, nil).
+				AddRow(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), "TEST", "TEST", 1.0, 0.0, 1.0, nil, nil, nil, nil)
+
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WillReturnRows(rows)
+
+			_, err = eod.AdjustAssetEodPrice(ctx, mock, "TEST")
+			Expect(err).To(MatchError(eod.ErrInvalidAdjustmentFactor))
+		})
+	})
+})
+
+var _ = Describe("save adjusted close prices", func() {
+	var (
+		ctx    context.Context
+		mock   pgxmock.PgxConnIface
+		prices []*eod.Eod
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+
+		prices = []*eod.Eod{
+			{EventDate: time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), Ticker: "TEST", CompositeFigi: "TEST", Close: 1.0, AdjClose: 1.0},
+			{EventDate: time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC), Ticker: "TEST", CompositeFigi: "TEST", Close: 1.0, AdjClose: 1.0},
+			{EventDate: time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), Ticker: "TEST", CompositeFigi: "TEST", Close: 1.0, AdjClose: .8},
+		}
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	Context("with fewer rows than the bulk threshold", func() {
+		It("should update each row individually", func() {
+			mock.ExpectBegin()
+			for _, price := range prices {
+				mock.ExpectExec("^UPDATE eod SET adj_close=(.+), adj_open=(.+), adj_high=(.+), adj_low=(.+), adj_volume=(.+) WHERE composite_figi=(.+) AND event_date=(.+)$").
+					WithArgs(price.AdjClose, null, null, null, null, price.CompositeFigi, price.EventDate).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+			}
+			mock.ExpectQuery("^DELETE FROM eod_adjustment_factors WHERE composite_figi = (.+) RETURNING (.+)$").WithArgs("TEST").WillReturnRows(mock.NewRows([]string{"event_date", "split_factor", "dividend_factor"}))
+			mock.ExpectCopyFrom(`"eod_adjustment_factors"`, []string{"composite_figi", "event_date", "split_factor", "dividend_factor"}).WillReturnResult(3)
+			dates := make([]time.Time, 0, len(prices))
+			for _, price := range prices {
+				dates = append(dates, price.EventDate)
+			}
+			mock.ExpectExec("^INSERT INTO eod_adj_close_history (.+) FROM eod e LEFT JOIN LATERAL (.+) WHERE e.composite_figi = (.+) AND e.event_date = ANY\\(\\$4\\) AND (.+)$").WithArgs("TEST", pgxmock.AnyArg(), pgxmock.AnyArg(), dates).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+			mock.ExpectCommit()
+
+			Expect(eod.SaveAdjCloseToDb(ctx, mock, prices)).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Context("with the split series selected", func() {
+		It("should write both adjusted columns", func() {
+			mock.ExpectBegin()
+			for _, price := range prices {
+				mock.ExpectExec("^UPDATE eod SET adj_close=(.+), adj_open=(.+), adj_high=(.+), adj_low=(.+), adj_volume=(.+), split_adj_close=(.+) WHERE composite_figi=(.+) AND event_date=(.+)$").
+					WithArgs(price.AdjClose, null, null, null, null, price.SplitAdjClose, price.CompositeFigi, price.EventDate).
+					WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+			}
+			mock.ExpectQuery("^DELETE FROM eod_adjustment_factors WHERE composite_figi = (.+) RETURNING (.+)$").WithArgs("TEST").WillReturnRows(mock.NewRows([]string{"event_date", "split_factor", "dividend_factor"}))
+			mock.ExpectCopyFrom(`"eod_adjustment_factors"`, []string{"composite_figi", "event_date", "split_factor", "dividend_factor"}).WillReturnResult(3)
+			mock.ExpectExec("^INSERT INTO eod_adj_close_history").WithArgs("TEST", pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+			mock.ExpectCommit()
+
+			Expect(eod.SaveAdjCloseToDb(ctx, mock, prices, eod.TotalReturnSeries, eod.SplitSeries)).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+
+	Context("with more rows than the bulk threshold", func() {
+		var threshold int
+
+		BeforeEach(func() {
+			threshold = eod.BulkSaveThreshold
+			eod.BulkSaveThreshold = 2
+		})
+
+		AfterEach(func() {
+			eod.BulkSaveThreshold = threshold
+		})
+
+		It("should copy rows into a staging table and update eod once", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("^CREATE TEMPORARY TABLE eod_adj_close_staging").WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+			mock.ExpectCopyFrom(`"eod_adj_close_staging"`, []string{"composite_figi", "event_date", "adj_close", "adj_open", "adj_high", "adj_low", "adj_volume", "split_adj_close", "net_adj_close", "split_factor", "dividend_factor"}).WillReturnResult(3)
+			mock.ExpectExec("^UPDATE eod SET adj_close = s.adj_close, (.+) FROM eod_adj_close_staging s").WillReturnResult(pgxmock.NewResult("UPDATE", 3))
+			mock.ExpectQuery("^DELETE FROM eod_adjustment_factors WHERE composite_figi = (.+) RETURNING (.+)$").WithArgs("TEST").WillReturnRows(mock.NewRows([]string{"event_date", "split_factor", "dividend_factor"}))
+			mock.ExpectCopyFrom(`"eod_adjustment_factors"`, []string{"composite_figi", "event_date", "split_factor", "dividend_factor"}).WillReturnResult(3)
+			mock.ExpectExec("^INSERT INTO eod_adj_close_history (.+) FROM eod e JOIN eod_adj_close_staging s (.+) WHERE e.composite_figi = (.+) AND e.adj_close IS DISTINCT FROM h.adj_close$").WithArgs("TEST", pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+			mock.ExpectCommit()
+
+			Expect(eod.SaveAdjCloseToDb(ctx, mock, prices)).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should rollback the transaction when the copy fails", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("^CREATE TEMPORARY TABLE eod_adj_close_staging").WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+			mock.ExpectCopyFrom(`"eod_adj_close_staging"`, []string{"composite_figi", "event_date", "adj_close", "adj_open", "adj_high", "adj_low", "adj_volume", "split_adj_close", "net_adj_close", "split_factor", "dividend_factor"}).WillReturnError(errors.New("copy failed"))
+			mock.ExpectRollback()
+
+			Expect(eod.SaveAdjCloseToDb(ctx, mock, prices)).ToNot(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+
+		It("should rollback the transaction when the update fails", func() {
+			mock.ExpectBegin()
+			mock.ExpectExec("^CREATE TEMPORARY TABLE eod_adj_close_staging").WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+			mock.ExpectCopyFrom(`"eod_adj_close_staging"`, []string{"composite_figi", "event_date", "adj_close", "adj_open", "adj_high", "adj_low", "adj_volume", "split_adj_close", "net_adj_close", "split_factor", "dividend_factor"}).WillReturnResult(3)
+			mock.ExpectExec("^UPDATE eod SET adj_close = s.adj_close, (.+) FROM eod_adj_close_staging s").WillReturnError(errors.New("update failed"))
+			mock.ExpectRollback()
+
+			Expect(eod.SaveAdjCloseToDb(ctx, mock, prices)).ToNot(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
 })
@@ -0,0 +1,221 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Revision identifies why adjusted prices were changed
+type Revision struct {
+	// RunID is the adjust run that made the change; 0 if it was not made
+	// by a recorded run
+	RunID int64
+
+	// Reason is a short description of the run such as "adjust recent". It
+	// is appended to the reason derived for each asset from its changed
+	// corporate actions, e.g. "new dividend on 2021-01-05 (adjust recent)".
+	Reason string
+}
+
+// maxReasonChanges is the number of changed ex-dates named in a revision
+// reason before the rest are summarized
+const maxReasonChanges = 3
+
+// factorTolerance is the relative difference below which two daily
+// adjustment factors are treated as equal
+const factorTolerance = 1e-9
+
+// factorChange is a change to the combined factor of the corporate actions
+// going ex on a date. The daily factors are the ratio of the cumulative
+// factors of the quote before the ex-date and the quote on it; 1 means there
+// was no action.
+type factorChange struct {
+	exDate      time.Time
+	oldSplit    float64
+	oldDividend float64
+	newSplit    float64
+	newDividend float64
+}
+
+// describeFactorChanges summarizes changed corporate actions for a revision
+// reason, e.g. "new dividend on 2021-01-05; split correction on 2020-06-01"
+func describeFactorChanges(changes []factorChange) string {
+	descriptions := make([]string, 0, len(changes))
+	for _, change := range changes {
+		date := change.exDate.Format("2006-01-02")
+		if kind := describeFactor("split", change.oldSplit, change.newSplit); kind != "" {
+			descriptions = append(descriptions, fmt.Sprintf("%s on %s", kind, date))
+		}
+		if kind := describeFactor("dividend", change.oldDividend, change.newDividend); kind != "" {
+			descriptions = append(descriptions, fmt.Sprintf("%s on %s", kind, date))
+		}
+	}
+
+	if len(descriptions) > maxReasonChanges {
+		more := len(descriptions) - maxReasonChanges
+		descriptions = append(descriptions[:maxReasonChanges], fmt.Sprintf("and %d more", more))
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// describeFactor names the change of a daily factor, or returns an empty
+// string if it did not change
+func describeFactor(action string, oldFactor, newFactor float64) string {
+	switch {
+	case !factorChanged(oldFactor, newFactor):
+		return ""
+	case !factorChanged(oldFactor, 1):
+		return "new " + action
+	case !factorChanged(newFactor, 1):
+		return "removed " + action
+	default:
+		return action + " correction"
+	}
+}
+
+// factorChanged returns true if the daily factors differ by more than
+// factorTolerance
+func factorChanged(oldFactor, newFactor float64) bool {
+	return math.Abs(newFactor-oldFactor) > factorTolerance*math.Abs(oldFactor)
+}
+
+// assetFactorChanges compares the daily factors of the newly adjusted prices
+// of an asset, sorted newest first, with the cumulative factors stored before
+// (keyed by YYYY-MM-DD). Dates without a stored factor take the factor of
+// the next newer date since no actions were known after them.
+func assetFactorChanges(stored map[string][2]float64, prices []*Eod) []factorChange {
+	previous := make([][2]float64, len(prices))
+	last := [2]float64{1, 1}
+	for idx, myEod := range prices {
+		if factors, ok := stored[myEod.EventDate.Format("2006-01-02")]; ok {
+			last = factors
+		}
+		previous[idx] = last
+	}
+
+	changes := make([]factorChange, 0)
+	for idx := 0; idx+1 < len(prices); idx++ {
+		exEod, cumEod := prices[idx], prices[idx+1]
+		if exEod.CumSplitFactor == 0 || exEod.CumDividendFactor == 0 || previous[idx][0] == 0 || previous[idx][1] == 0 {
+			continue
+		}
+		change := factorChange{
+			exDate:      exEod.EventDate,
+			oldSplit:    previous[idx+1][0] / previous[idx][0],
+			oldDividend: previous[idx+1][1] / previous[idx][1],
+			newSplit:    cumEod.CumSplitFactor / exEod.CumSplitFactor,
+			newDividend: cumEod.CumDividendFactor / exEod.CumDividendFactor,
+		}
+		if factorChanged(change.oldSplit, change.newSplit) || factorChanged(change.oldDividend, change.newDividend) {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// revisionReason combines the reason derived from an asset's changed
+// corporate actions with the reason of the run
+func revisionReason(derived string, revision *Revision) pgtype.Text {
+	reason := derived
+	if revision != nil && revision.Reason != "" {
+		if reason == "" {
+			reason = revision.Reason
+		} else {
+			reason = fmt.Sprintf("%s (%s)", reason, revision.Reason)
+		}
+	}
+	if reason == "" {
+		return pgtype.Text{Status: pgtype.Null}
+	}
+	return pgtype.Text{String: reason, Status: pgtype.Present}
+}
+
+// AdjCloseRevision is the adj_close of an eod quote as recorded at a moment in
+// time
+type AdjCloseRevision struct {
+	EventDate  time.Time
+	AdjClose   pgtype.Float8
+	RunID      pgtype.Int8
+	RecordedAt time.Time
+	Reason     pgtype.Text
+}
+
+// saveAdjCloseHistory records the adj_close of every saved quote of the
+// assets in figis that differs from its latest recorded revision. The saved
+// quotes are the ones in eod_adj_close_staging or, when dates is not nil, the
+// dates listed for each asset. reasons holds the changed corporate actions of
+// each asset. It must run after eod has been updated.
+func saveAdjCloseHistory(ctx context.Context, tx pgx.Tx, figis []string, dates map[string][]time.Time, revision *Revision, reasons map[string]string) error {
+	runID := pgtype.Int8{Status: pgtype.Null}
+	if revision != nil && revision.RunID != 0 {
+		runID = pgtype.Int8{Int: revision.RunID, Status: pgtype.Present}
+	}
+
+	sql := `INSERT INTO eod_adj_close_history (composite_figi, event_date, adj_close, run_id, reason) SELECT e.composite_figi, e.event_date, e.adj_close, $2, $3 FROM eod e JOIN eod_adj_close_staging s ON s.composite_figi = e.composite_figi AND s.event_date = e.event_date LEFT JOIN LATERAL (SELECT h.adj_close FROM eod_adj_close_history h WHERE h.composite_figi = e.composite_figi AND h.event_date = e.event_date ORDER BY h.recorded_at DESC, h.revision_id DESC LIMIT 1) h ON true WHERE e.composite_figi = $1 AND e.adj_close IS DISTINCT FROM h.adj_close`
+	if dates != nil {
+		sql = `INSERT INTO eod_adj_close_history (composite_figi, event_date, adj_close, run_id, reason) SELECT e.composite_figi, e.event_date, e.adj_close, $2, $3 FROM eod e LEFT JOIN LATERAL (SELECT h.adj_close FROM eod_adj_close_history h WHERE h.composite_figi = e.composite_figi AND h.event_date = e.event_date ORDER BY h.recorded_at DESC, h.revision_id DESC LIMIT 1) h ON true WHERE e.composite_figi = $1 AND e.event_date = ANY($4) AND e.adj_close IS DISTINCT FROM h.adj_close`
+	}
+
+	for _, compositeFigi := range figis {
+		args := []interface{}{compositeFigi, runID, revisionReason(reasons[compositeFigi], revision)}
+		if dates != nil {
+			args = append(args, dates[compositeFigi])
+		}
+		if _, err := tx.Exec(ctx, sql, args...); err != nil {
+			log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not record adj_close history")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AdjCloseAt reconstructs the adjusted close series of an asset as it was
+// stored at a past moment, oldest quote first. Quotes that had not been
+// adjusted by then are omitted.
+func AdjCloseAt(ctx context.Context, conn PgxIface, compositeFigi string, at time.Time) ([]*AdjCloseRevision, error) {
+	revisions := make([]*AdjCloseRevision, 0)
+
+	rows, err := conn.Query(ctx, `SELECT DISTINCT ON (event_date) event_date, adj_close, run_id, recorded_at, reason FROM eod_adj_close_history WHERE composite_figi = $1 AND recorded_at <= $2 ORDER BY event_date, recorded_at DESC, revision_id DESC`, compositeFigi, at)
+	if err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Time("At", at).Msg("could not query adj_close history")
+		return revisions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		revision := &AdjCloseRevision{}
+		if err := rows.Scan(&revision.EventDate, &revision.AdjClose, &revision.RunID, &revision.RecordedAt, &revision.Reason); err != nil {
+			log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not scan adj_close history")
+			return revisions, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not read adj_close history")
+		return revisions, err
+	}
+
+	return revisions, nil
+}
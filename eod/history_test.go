@@ -0,0 +1,140 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("adj_close history", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+		day1 time.Time
+		day2 time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+
+		day1 = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		day2 = time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	expectSave := func(prices []*eod.Eod, stored *pgxmock.Rows) {
+		mock.ExpectBegin()
+		for _, price := range prices {
+			mock.ExpectExec("^UPDATE eod SET adj_close=(.+) WHERE composite_figi=(.+) AND event_date=(.+)$").
+				WithArgs(price.AdjClose, pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg(), "TEST", price.EventDate).
+				WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+		}
+		mock.ExpectQuery("^DELETE FROM eod_adjustment_factors WHERE composite_figi = (.+) RETURNING event_date, split_factor, dividend_factor$").WithArgs("TEST").WillReturnRows(stored)
+		mock.ExpectCopyFrom(`"eod_adjustment_factors"`, []string{"composite_figi", "event_date", "split_factor", "dividend_factor"}).WillReturnResult(int64(len(prices)))
+	}
+
+	noFactors := func() *pgxmock.Rows {
+		return mock.NewRows([]string{"event_date", "split_factor", "dividend_factor"})
+	}
+
+	prices := func() []*eod.Eod {
+		return []*eod.Eod{
+			{CompositeFigi: "TEST", EventDate: day1, AdjClose: 10.0, CumSplitFactor: 1, CumDividendFactor: 1},
+		}
+	}
+
+	It("should append the reason of the run to the derived reason", func() {
+		expectSave(prices(), noFactors())
+		mock.ExpectExec("^INSERT INTO eod_adj_close_history (.+) WHERE e.composite_figi = (.+) AND e.event_date = ANY\\(\\$4\\) AND e.adj_close IS DISTINCT FROM h.adj_close$").
+			WithArgs("TEST", pgtype.Int8{Status: pgtype.Null}, pgtype.Text{String: "initial adjustment (adjust recent)", Status: pgtype.Present}, []time.Time{day1}).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		mock.ExpectCommit()
+
+		Expect(eod.SaveAdjCloseRevision(ctx, mock, prices(), &eod.Revision{Reason: "adjust recent"})).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should derive the reason from the changed corporate actions", func() {
+		// the stored factors had a 3:1 split and no dividend on day 2; the
+		// split is now 2:1 and a dividend was added
+		changed := []*eod.Eod{
+			{CompositeFigi: "TEST", EventDate: day2, AdjClose: 10.0, CumSplitFactor: 1, CumDividendFactor: 1},
+			{CompositeFigi: "TEST", EventDate: day1, AdjClose: 5.0, CumSplitFactor: 2, CumDividendFactor: 1.1},
+		}
+		expectSave(changed, noFactors().AddRow(day2, 1.0, 1.0).AddRow(day1, 3.0, 1.0))
+		mock.ExpectExec("^INSERT INTO eod_adj_close_history").
+			WithArgs("TEST", pgtype.Int8{Status: pgtype.Null}, pgtype.Text{String: "split correction on 2021-01-02; new dividend on 2021-01-02", Status: pgtype.Present}, []time.Time{day2, day1}).
+			WillReturnResult(pgxmock.NewResult("INSERT", 2))
+		mock.ExpectCommit()
+
+		Expect(eod.SaveAdjCloseRevision(ctx, mock, changed, &eod.Revision{})).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should record values saved outside a run without a run or reason", func() {
+		expectSave(prices(), noFactors().AddRow(day1, 1.0, 1.0))
+		mock.ExpectExec("^INSERT INTO eod_adj_close_history").
+			WithArgs("TEST", pgtype.Int8{Status: pgtype.Null}, pgtype.Text{Status: pgtype.Null}, []time.Time{day1}).
+			WillReturnResult(pgxmock.NewResult("INSERT", 0))
+		mock.ExpectCommit()
+
+		Expect(eod.SaveAdjCloseToDb(ctx, mock, prices())).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should roll back the update if the history cannot be recorded", func() {
+		expectSave(prices(), noFactors())
+		mock.ExpectExec("^INSERT INTO eod_adj_close_history").
+			WithArgs("TEST", pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).
+			WillReturnError(errors.New("history failed"))
+		mock.ExpectRollback()
+
+		Expect(eod.SaveAdjCloseRevision(ctx, mock, prices(), &eod.Revision{Reason: "new dividend"})).ToNot(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should reconstruct the series stored at a past moment", func() {
+		at := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+		recorded := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("^SELECT DISTINCT ON \\(event_date\\) (.+) FROM eod_adj_close_history WHERE composite_figi = (.+) AND recorded_at <= (.+) ORDER BY event_date, recorded_at DESC, revision_id DESC$").
+			WithArgs("TEST", at).
+			WillReturnRows(mock.NewRows([]string{"event_date", "adj_close", "run_id", "recorded_at", "reason"}).
+				AddRow(day1, 9.5, int64(3), recorded, "new dividend").
+				AddRow(day2, 10.0, nil, recorded, nil))
+
+		revisions, err := eod.AdjCloseAt(ctx, mock, "TEST", at)
+		Expect(err).To(BeNil())
+		Expect(revisions).To(HaveLen(2))
+		Expect(revisions[0].AdjClose.Float).To(Equal(9.5))
+		Expect(revisions[0].RunID.Int).To(Equal(int64(3)))
+		Expect(revisions[0].Reason.String).To(Equal("new dividend"))
+		Expect(revisions[1].RunID.Status).ToNot(Equal(pgtype.Present))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
@@ -0,0 +1,103 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrInvalidClose           = errors.New("close price is zero, negative or missing")
+	ErrUnknownZeroPricePolicy = errors.New("unknown zero price policy")
+)
+
+// ZeroPricePolicy controls how quotes with a zero, negative or missing close
+// are treated during adjustment. The dividend on such a quote cannot be
+// converted into a CRSP factor and is always ignored; its split factor is
+// still applied unless the asset is aborted.
+type ZeroPricePolicy string
+
+const (
+	// CarryFactor keeps the quote and carries the cumulative factor through it;
+	// a missing close keeps a NULL adjusted close
+	CarryFactor ZeroPricePolicy = "carry"
+
+	// SkipRow leaves the quote out of the adjusted history so its stored
+	// adjusted prices are not changed
+	SkipRow ZeroPricePolicy = "skip"
+
+	// AbortAsset stops adjusting the asset and returns ErrInvalidClose
+	AbortAsset ZeroPricePolicy = "abort"
+)
+
+// Reasons a quote is quarantined
+const (
+	QuarantineMissingClose  = "missing close"
+	QuarantineZeroClose     = "zero close"
+	QuarantineNegativeClose = "negative close"
+)
+
+// ParseZeroPricePolicy converts a policy name into a ZeroPricePolicy
+func ParseZeroPricePolicy(name string) (ZeroPricePolicy, error) {
+	switch ZeroPricePolicy(name) {
+	case CarryFactor, SkipRow, AbortAsset:
+		return ZeroPricePolicy(name), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownZeroPricePolicy, name)
+	}
+}
+
+// QuarantineRecord is an eod quote that could not be adjusted normally
+type QuarantineRecord struct {
+	CompositeFigi string
+	EventDate     time.Time
+	Ticker        string
+	Close         pgtype.Float8
+	Reason        string
+	Policy        ZeroPricePolicy
+}
+
+// quarantineReason returns the reason a close price must be quarantined or an
+// empty string if it is valid
+func quarantineReason(closePrice pgtype.Float8) string {
+	switch {
+	case closePrice.Status != pgtype.Present:
+		return QuarantineMissingClose
+	case closePrice.Float == 0:
+		return QuarantineZeroClose
+	case closePrice.Float < 0:
+		return QuarantineNegativeClose
+	default:
+		return ""
+	}
+}
+
+// SaveQuarantineToDb records quarantined quotes in eod_quarantine
+func SaveQuarantineToDb(ctx context.Context, conn PgxIface, records []*QuarantineRecord) error {
+	for _, record := range records {
+		if _, err := conn.Exec(ctx, `INSERT INTO eod_quarantine (composite_figi, event_date, ticker, close, reason, policy) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (composite_figi, event_date, reason) DO UPDATE SET ticker = EXCLUDED.ticker, close = EXCLUDED.close, policy = EXCLUDED.policy, detected_at = now()`,
+			record.CompositeFigi, record.EventDate, record.Ticker, nullIfUndefined(record.Close), record.Reason, string(record.Policy)); err != nil {
+			log.Error().Err(err).Str("CompositeFigi", record.CompositeFigi).Time("EventDate", record.EventDate).Msg("could not save quarantined eod quote")
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("zero and missing close prices", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+		day1 time.Time
+		day2 time.Time
+		day3 time.Time
+		day4 time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+
+		day1 = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		day2 = time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+		day3 = time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)
+		day4 = time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)
+
+		// 2:1 split on day 4, zero close with a 2:1 split on day 3 and a
+		// missing close on day 2
+		rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+			AddRow(day4, "TEST", "TEST", 10.0, 0.0, 2.0, nil, nil, nil, nil).
+			AddRow(day3, "TEST", "TEST", 0.0, 1.0, 2.0, nil, nil, nil, nil).
+			AddRow(day2, "TEST", "TEST", nil, 0.0, 1.0, nil, nil, nil, nil).
+			AddRow(day1, "TEST", "TEST", 40.0, 0.0, 1.0, nil, nil, nil, nil)
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WithArgs("TEST").WillReturnRows(rows)
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	expectQuarantine := func(policy eod.ZeroPricePolicy) {
+		mock.ExpectExec("^INSERT INTO eod_quarantine").
+			WithArgs("TEST", day3, "TEST", pgxmock.AnyArg(), eod.QuarantineZeroClose, string(policy)).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		if policy != eod.AbortAsset {
+			mock.ExpectExec("^INSERT INTO eod_quarantine").
+				WithArgs("TEST", day2, "TEST", pgxmock.AnyArg(), eod.QuarantineMissingClose, string(policy)).
+				WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		}
+	}
+
+	It("should carry the factor through invalid quotes by default", func() {
+		expectQuarantine(eod.CarryFactor)
+
+		prices, err := eod.AdjustAssetEodPrice(ctx, mock, "TEST")
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(4))
+		Expect(prices[1].AdjClose).To(Equal(0.0))
+		Expect(prices[2].CumSplitFactor).To(Equal(4.0))
+		Expect(prices[3].AdjClose).To(Equal(10.0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should keep the adjusted close of a missing close NULL", func() {
+		expectQuarantine(eod.CarryFactor)
+
+		prices, err := eod.AdjustAssetEodPrice(ctx, mock, "TEST")
+		Expect(err).To(BeNil())
+		Expect(prices[1].MissingClose).To(BeFalse())
+		Expect(prices[2].MissingClose).To(BeTrue())
+		Expect(eod.TotalReturnSeries.Values(prices[1])[0]).To(Equal(0.0))
+		Expect(eod.TotalReturnSeries.Values(prices[2])[0]).To(Equal(pgtype.Float8{Status: pgtype.Null}))
+		Expect(eod.SplitSeries.Values(prices[2])[0]).To(Equal(pgtype.Float8{Status: pgtype.Null}))
+
+		records := eod.AdjustedPriceRecords(prices)
+		Expect(records[2].AdjClose).To(BeNil())
+		Expect(*records[3].AdjClose).To(Equal(10.0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should leave skipped quotes out of the adjusted history", func() {
+		expectQuarantine(eod.SkipRow)
+
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{ZeroPricePolicy: eod.SkipRow})
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(2))
+		Expect(prices[0].EventDate).To(Equal(day4))
+		Expect(prices[1].EventDate).To(Equal(day1))
+		Expect(prices[1].AdjClose).To(Equal(10.0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should abort the asset", func() {
+		expectQuarantine(eod.AbortAsset)
+
+		_, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{ZeroPricePolicy: eod.AbortAsset})
+		Expect(err).To(MatchError(eod.ErrInvalidClose))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should not record quarantined quotes on a dry run", func() {
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{DryRun: true})
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(4))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
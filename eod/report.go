@@ -0,0 +1,139 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gocarina/gocsv"
+	"github.com/jackc/pgtype"
+)
+
+var (
+	ErrUnknownReportFormat = errors.New("unknown report format")
+)
+
+// DriftRecord is a row of a drift report
+type DriftRecord struct {
+	CompositeFigi string   `csv:"composite_figi" json:"composite_figi"`
+	EventDate     string   `csv:"event_date" json:"event_date"`
+	Column        string   `csv:"column" json:"column"`
+	Stored        *float64 `csv:"stored" json:"stored"`
+	Calculated    *float64 `csv:"calculated" json:"calculated"`
+}
+
+// DriftRecords flattens the changed values of each diff into report records
+func DriftRecords(diffs []*AdjCloseDiff) []*DriftRecord {
+	records := make([]*DriftRecord, 0)
+	for _, diff := range diffs {
+		for _, change := range diff.Changes {
+			records = append(records, &DriftRecord{
+				CompositeFigi: diff.CompositeFigi,
+				EventDate:     change.EventDate.Format("2006-01-02"),
+				Column:        change.Column,
+				Stored:        float8Ptr(change.Stored),
+				Calculated:    float8Ptr(change.Calculated),
+			})
+		}
+	}
+	return records
+}
+
+// WriteDriftReport writes every changed row in diffs to w as either json or csv
+func WriteDriftReport(w io.Writer, format string, diffs []*AdjCloseDiff) error {
+	return writeReport(w, format, DriftRecords(diffs))
+}
+
+// AdjustedPriceRecord is a row of an adjusted price export
+type AdjustedPriceRecord struct {
+	CompositeFigi string   `csv:"composite_figi" json:"composite_figi"`
+	Ticker        string   `csv:"ticker" json:"ticker"`
+	EventDate     string   `csv:"event_date" json:"event_date"`
+	Close         float64  `csv:"close" json:"close"`
+	Dividend      float64  `csv:"dividend" json:"dividend"`
+	SplitFactor   float64  `csv:"split_factor" json:"split_factor"`
+	AdjClose      *float64 `csv:"adj_close" json:"adj_close"`
+	SplitAdjClose *float64 `csv:"split_adj_close" json:"split_adj_close"`
+	NetAdjClose   *float64 `csv:"net_adj_close" json:"net_adj_close"`
+	AdjOpen       *float64 `csv:"adj_open" json:"adj_open"`
+	AdjHigh       *float64 `csv:"adj_high" json:"adj_high"`
+	AdjLow        *float64 `csv:"adj_low" json:"adj_low"`
+	AdjVolume     *float64 `csv:"adj_volume" json:"adj_volume"`
+	Synthetic     bool     `csv:"synthetic" json:"synthetic"`
+}
+
+// AdjustedPriceRecords converts adjusted prices into export records. The
+// prices must be adjusted with every series in AllSeries; otherwise
+// NetAdjClose is calculated without withholding tax.
+func AdjustedPriceRecords(prices []*Eod) []*AdjustedPriceRecord {
+	records := make([]*AdjustedPriceRecord, len(prices))
+	for idx, myEod := range prices {
+		records[idx] = &AdjustedPriceRecord{
+			CompositeFigi: myEod.CompositeFigi,
+			Ticker:        myEod.Ticker,
+			EventDate:     myEod.EventDate.Format("2006-01-02"),
+			Close:         myEod.Close,
+			Dividend:      myEod.Dividend,
+			SplitFactor:   myEod.SplitFactor,
+			AdjClose:      closePtr(myEod, myEod.AdjClose),
+			SplitAdjClose: closePtr(myEod, myEod.SplitAdjClose),
+			NetAdjClose:   closePtr(myEod, myEod.NetAdjClose),
+			AdjOpen:       float8Ptr(myEod.AdjOpen),
+			AdjHigh:       float8Ptr(myEod.AdjHigh),
+			AdjLow:        float8Ptr(myEod.AdjLow),
+			AdjVolume:     float8Ptr(myEod.AdjVolume),
+			Synthetic:     myEod.Synthetic,
+		}
+	}
+	return records
+}
+
+// WriteAdjustedPrices writes prices to w as either json or csv
+func WriteAdjustedPrices(w io.Writer, format string, prices []*Eod) error {
+	return writeReport(w, format, AdjustedPriceRecords(prices))
+}
+
+// float8Ptr returns nil for NULL values so they are written as empty
+func float8Ptr(val pgtype.Float8) *float64 {
+	if val.Status != pgtype.Present {
+		return nil
+	}
+	return &val.Float
+}
+
+// closePtr returns nil for the adjusted closes of quotes without a close
+func closePtr(myEod *Eod, val float64) *float64 {
+	if myEod.MissingClose {
+		return nil
+	}
+	return &val
+}
+
+// writeReport writes a slice of tagged records to w as either json or csv
+func writeReport(w io.Writer, format string, records interface{}) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "csv":
+		return gocsv.Marshal(records, w)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownReportFormat, format)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("drift reports", func() {
+	var diffs []*eod.AdjCloseDiff
+
+	BeforeEach(func() {
+		stored := pgtype.Float8{Float: 9.5, Status: pgtype.Present}
+		diffs = []*eod.AdjCloseDiff{
+			{
+				CompositeFigi: "AAA",
+				Rows:          2,
+				RowsChanged:   2,
+				Changes: []*eod.AdjCloseChange{
+					{EventDate: time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), Column: "adj_close", Stored: stored, Calculated: pgtype.Float8{Float: 10, Status: pgtype.Present}},
+					{EventDate: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), Column: "adj_open", Stored: pgtype.Float8{Status: pgtype.Null}, Calculated: pgtype.Float8{Float: 9, Status: pgtype.Present}},
+				},
+			},
+		}
+	})
+
+	It("should write a json report", func() {
+		buf := &bytes.Buffer{}
+		Expect(eod.WriteDriftReport(buf, "json", diffs)).To(Succeed())
+
+		records := make([]*eod.DriftRecord, 0)
+		Expect(json.Unmarshal(buf.Bytes(), &records)).To(Succeed())
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].CompositeFigi).To(Equal("AAA"))
+		Expect(records[0].EventDate).To(Equal("2021-01-02"))
+		Expect(records[0].Column).To(Equal("adj_close"))
+		Expect(*records[0].Stored).To(Equal(9.5))
+		Expect(records[1].Stored).To(BeNil())
+		Expect(*records[1].Calculated).To(Equal(9.0))
+	})
+
+	It("should write a csv report", func() {
+		buf := &bytes.Buffer{}
+		Expect(eod.WriteDriftReport(buf, "csv", diffs)).To(Succeed())
+		Expect(buf.String()).To(Equal("composite_figi,event_date,column,stored,calculated\nAAA,2021-01-02,adj_close,9.5,10\nAAA,2021-01-01,adj_open,,9\n"))
+	})
+
+	It("should reject unknown formats", func() {
+		Expect(eod.WriteDriftReport(&bytes.Buffer{}, "xml", diffs)).To(MatchError(eod.ErrUnknownReportFormat))
+	})
+})
+
+var _ = Describe("adjusted price exports", func() {
+	It("should write a csv export", func() {
+		prices := []*eod.Eod{
+			{
+				CompositeFigi: "AAA",
+				Ticker:        "A",
+				EventDate:     time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC),
+				Close:         20,
+				Dividend:      1,
+				SplitFactor:   1,
+				AdjClose:      19,
+				SplitAdjClose: 20,
+				NetAdjClose:   19.5,
+				AdjOpen:       pgtype.Float8{Float: 18, Status: pgtype.Present},
+			},
+		}
+
+		buf := &bytes.Buffer{}
+		Expect(eod.WriteAdjustedPrices(buf, "csv", prices)).To(Succeed())
+		Expect(buf.String()).To(Equal("composite_figi,ticker,event_date,close,dividend,split_factor,adj_close,split_adj_close,net_adj_close,adj_open,adj_high,adj_low,adj_volume,synthetic\nAAA,A,2021-01-02,20,1,1,19,20,19.5,18,,,,false\n"))
+	})
+})
@@ -0,0 +1,222 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// RunRolledBack is the status of a run whose changes were undone by
+// RollbackRun
+const RunRolledBack = "rolled_back"
+
+var (
+	ErrUnknownRun       = errors.New("unknown adjust run")
+	ErrRunInProgress    = errors.New("adjust run has not finished")
+	ErrRunAlreadyUndone = errors.New("adjust run was already rolled back")
+	ErrRunChanged       = errors.New("adjusted prices changed since the run")
+)
+
+// runFactorColumns are the eod_adjustment_factors columns staged next to the
+// eod columns of AllSeries and recorded in adjust_run_factors
+var runFactorColumns = []string{"split_factor", "dividend_factor"}
+
+// saveRunChanges records every quote the run in revision is about to change
+// in adjust_run_changes: the adjusted prices stored before and the ones
+// written in their place. The new values are read from source, a table or CTE
+// with composite_figi, event_date and the columns of series; query defines
+// source when it is a CTE and takes args. It must run before eod is updated
+// and is a no-op for saves outside a run.
+func saveRunChanges(ctx context.Context, tx pgx.Tx, query string, args []interface{}, source string, series []Series, revision *Revision) error {
+	if revision == nil || revision.RunID == 0 {
+		return nil
+	}
+
+	written := make(map[string]bool)
+	for _, s := range series {
+		for _, col := range s.Columns() {
+			written[col] = true
+		}
+	}
+
+	columns := []string{"run_id", "composite_figi", "event_date"}
+	values := []string{fmt.Sprintf("$%d", len(args)+1), "eod.composite_figi", "eod.event_date"}
+	changed := make([]string, 0)
+	updates := make([]string, 0)
+	for _, s := range AllSeries {
+		for _, col := range s.Columns() {
+			newValue := "eod." + col
+			if written[col] {
+				newValue = "s." + col
+				changed = append(changed, fmt.Sprintf("eod.%[1]s IS DISTINCT FROM s.%[1]s", col))
+			}
+			columns = append(columns, "previous_"+col, col)
+			values = append(values, "eod."+col, newValue)
+			updates = append(updates, fmt.Sprintf("%[1]s = EXCLUDED.%[1]s", col))
+		}
+	}
+
+	sql := fmt.Sprintf(`%sINSERT INTO adjust_run_changes (%s) SELECT DISTINCT ON (eod.composite_figi, eod.event_date) %s FROM eod JOIN %s s ON s.composite_figi = eod.composite_figi AND s.event_date = eod.event_date WHERE %s ORDER BY eod.composite_figi, eod.event_date, %s, eod.ticker ON CONFLICT (run_id, composite_figi, event_date) DO UPDATE SET %s`,
+		query, strings.Join(columns, ", "), strings.Join(values, ", "), source, strings.Join(changed, " OR "), tickerInEffect, strings.Join(updates, ", "))
+
+	if _, err := tx.Exec(ctx, sql, append(args, revision.RunID)...); err != nil {
+		log.Error().Err(err).Int64("RunID", revision.RunID).Msg("could not record adjusted prices changed by run")
+		return err
+	}
+
+	return nil
+}
+
+// saveRunFactors records the rows of eod_adjustment_factors the run in
+// revision is about to add, change or remove for the assets in figis in
+// adjust_run_factors, comparing the stored factors with the ones in
+// eod_adj_close_staging, which replace them. It must run before the factors
+// are replaced and is a no-op for saves outside a run.
+func saveRunFactors(ctx context.Context, tx pgx.Tx, figis []string, revision *Revision) error {
+	if revision == nil || revision.RunID == 0 {
+		return nil
+	}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO adjust_run_factors (run_id, composite_figi, event_date, previous_split_factor, split_factor, previous_dividend_factor, dividend_factor) SELECT $2, COALESCE(f.composite_figi, s.composite_figi), COALESCE(f.event_date, s.event_date), f.split_factor, s.split_factor, f.dividend_factor, s.dividend_factor FROM (SELECT composite_figi, event_date, split_factor, dividend_factor FROM eod_adjustment_factors WHERE composite_figi = ANY($1)) f FULL JOIN eod_adj_close_staging s ON s.composite_figi = f.composite_figi AND s.event_date = f.event_date WHERE f.split_factor IS DISTINCT FROM s.split_factor OR f.dividend_factor IS DISTINCT FROM s.dividend_factor ON CONFLICT (run_id, composite_figi, event_date) DO UPDATE SET split_factor = EXCLUDED.split_factor, dividend_factor = EXCLUDED.dividend_factor`,
+		figis, revision.RunID); err != nil {
+		log.Error().Err(err).Int64("RunID", revision.RunID).Msg("could not record adjustment factors changed by run")
+		return err
+	}
+
+	return nil
+}
+
+// RollbackRun restores the adjusted prices and adjustment factors overwritten
+// by an adjust run and returns the number of quotes restored. The rollback is
+// atomic and is refused with ErrRunChanged if any of the quotes have been
+// revised since the run started, by a later run or a save outside a run;
+// later runs that were rolled back do not count. The restored adj_close values
+// are recorded in eod_adj_close_history under the run with the reason
+// "rollback of run RUN_ID". A quote or adjustment factor no longer holding
+// the value the run wrote counts as revised, so changes that leave no history
+// are caught too.
+func RollbackRun(ctx context.Context, conn PgxIface, runID int64) (int64, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("could not begin db transaction to roll back adjust run")
+		return 0, err
+	}
+
+	restored, err := rollbackRun(ctx, tx, runID)
+	if err != nil {
+		if err2 := tx.Rollback(ctx); err2 != nil {
+			log.Error().Err(err2).Msg("failed to rollback db transaction")
+		}
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Int64("RunID", runID).Msg("could not commit adjust run rollback")
+		return 0, err
+	}
+
+	return restored, nil
+}
+
+func rollbackRun(ctx context.Context, tx pgx.Tx, runID int64) (int64, error) {
+	var status string
+	err := tx.QueryRow(ctx, `SELECT status FROM adjust_runs WHERE run_id = $1 FOR UPDATE`, runID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("%w: %d", ErrUnknownRun, runID)
+	}
+	if err != nil {
+		log.Error().Err(err).Int64("RunID", runID).Msg("could not load adjust run")
+		return 0, err
+	}
+
+	switch status {
+	case RunRunning:
+		return 0, fmt.Errorf("%w: %d", ErrRunInProgress, runID)
+	case RunRolledBack:
+		return 0, fmt.Errorf("%w: %d", ErrRunAlreadyUndone, runID)
+	}
+
+	var numChanges int64
+	if err := tx.QueryRow(ctx, `SELECT count(*) FROM adjust_run_changes WHERE run_id = $1`, runID).Scan(&numChanges); err != nil {
+		log.Error().Err(err).Int64("RunID", runID).Msg("could not count adjust run changes")
+		return 0, err
+	}
+
+	// quotes revised after the run started by anything but the run itself
+	// or a run that was rolled back since (including its rollback), or whose
+	// adjusted prices no longer hold the values the run wrote, are left
+	// alone and the whole rollback is abandoned
+	differs := make([]string, 0)
+	sets := make([]string, 0)
+	for _, s := range AllSeries {
+		for _, col := range s.Columns() {
+			differs = append(differs, fmt.Sprintf("e.%[1]s IS DISTINCT FROM c.%[1]s", col))
+			sets = append(sets, fmt.Sprintf("%[1]s = c.previous_%[1]s", col))
+		}
+	}
+
+	var numRevised int64
+	if err := tx.QueryRow(ctx, fmt.Sprintf(`SELECT count(*) FROM adjust_run_changes c JOIN adjust_runs r ON r.run_id = c.run_id WHERE c.run_id = $1 AND (EXISTS (SELECT 1 FROM eod e WHERE e.composite_figi = c.composite_figi AND e.event_date = c.event_date AND (%s)) OR EXISTS (SELECT 1 FROM eod_adj_close_history h WHERE h.composite_figi = c.composite_figi AND h.event_date = c.event_date AND h.recorded_at >= r.started_at AND h.run_id IS DISTINCT FROM c.run_id AND NOT EXISTS (SELECT 1 FROM adjust_runs hr WHERE hr.run_id = h.run_id AND hr.status = $2)) OR EXISTS (SELECT 1 FROM adjust_run_changes l JOIN adjust_runs lr ON lr.run_id = l.run_id WHERE l.composite_figi = c.composite_figi AND l.event_date = c.event_date AND l.run_id > c.run_id AND lr.status <> $2))`, strings.Join(differs, " OR ")),
+		runID, RunRolledBack).Scan(&numRevised); err != nil {
+		log.Error().Err(err).Int64("RunID", runID).Msg("could not check for revisions after the run")
+		return 0, err
+	}
+
+	// a factor the run wrote is gone or different, or one it removed is back
+	var numFactorsRevised int64
+	if err := tx.QueryRow(ctx, `SELECT count(*) FROM adjust_run_factors c LEFT JOIN eod_adjustment_factors f ON f.composite_figi = c.composite_figi AND f.event_date = c.event_date WHERE c.run_id = $1 AND (f.split_factor IS DISTINCT FROM c.split_factor OR f.dividend_factor IS DISTINCT FROM c.dividend_factor)`, runID).Scan(&numFactorsRevised); err != nil {
+		log.Error().Err(err).Int64("RunID", runID).Msg("could not check for adjustment factor revisions after the run")
+		return 0, err
+	}
+
+	if numRevised+numFactorsRevised > 0 {
+		log.Error().Int64("RunID", runID).Int64("Changes", numChanges).Int64("Revised", numRevised).Int64("FactorsRevised", numFactorsRevised).Msg("adjusted prices were changed after the run; refusing to roll back")
+		return 0, fmt.Errorf("%w: %d of %d quotes and %d adjustment factors changed since run %d", ErrRunChanged, numRevised, numChanges, numFactorsRevised, runID)
+	}
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf(`UPDATE eod e SET %s FROM adjust_run_changes c WHERE c.run_id = $1 AND e.composite_figi = c.composite_figi AND e.event_date = c.event_date`, strings.Join(sets, ", ")), runID); err != nil {
+		log.Error().Err(err).Int64("RunID", runID).Msg("could not restore previous adjusted prices")
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM eod_adjustment_factors f USING adjust_run_factors c WHERE c.run_id = $1 AND f.composite_figi = c.composite_figi AND f.event_date = c.event_date AND c.previous_split_factor IS NULL`, runID); err != nil {
+		log.Error().Err(err).Int64("RunID", runID).Msg("could not remove adjustment factors added by the run")
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO eod_adjustment_factors (composite_figi, event_date, split_factor, dividend_factor) SELECT composite_figi, event_date, previous_split_factor, previous_dividend_factor FROM adjust_run_factors WHERE run_id = $1 AND previous_split_factor IS NOT NULL ON CONFLICT (composite_figi, event_date) DO UPDATE SET split_factor = EXCLUDED.split_factor, dividend_factor = EXCLUDED.dividend_factor`, runID); err != nil {
+		log.Error().Err(err).Int64("RunID", runID).Msg("could not restore previous adjustment factors")
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO eod_adj_close_history (composite_figi, event_date, adj_close, run_id, reason) SELECT composite_figi, event_date, previous_adj_close, run_id, $2 FROM adjust_run_changes WHERE run_id = $1 AND previous_adj_close IS DISTINCT FROM adj_close`,
+		runID, fmt.Sprintf("rollback of run %d", runID)); err != nil {
+		log.Error().Err(err).Int64("RunID", runID).Msg("could not record adj_close history")
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE adjust_runs SET status = $1 WHERE run_id = $2`, RunRolledBack, runID); err != nil {
+		log.Error().Err(err).Int64("RunID", runID).Msg("could not record adjust run rollback")
+		return 0, err
+	}
+
+	return numChanges, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("adjust run rollback", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+		day1 time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+
+		day1 = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	expectCount := func(changes int64) {
+		mock.ExpectQuery("^SELECT count\\(\\*\\) FROM adjust_run_changes WHERE run_id = (.+)$").
+			WithArgs(int64(7)).
+			WillReturnRows(mock.NewRows([]string{"count"}).AddRow(changes))
+	}
+
+	expectRevised := func(revised, factorsRevised int64) {
+		mock.ExpectQuery("^SELECT count\\(\\*\\) FROM adjust_run_changes c JOIN adjust_runs r (.+) FROM eod e (.+)\\(e.adj_close IS DISTINCT FROM c.adj_close OR (.+) e.net_adj_close IS DISTINCT FROM c.net_adj_close(.+) FROM eod_adj_close_history h (.+) h.recorded_at >= r.started_at (.+) l.run_id > c.run_id AND lr.status <> (.+)\\)\\)$").
+			WithArgs(int64(7), eod.RunRolledBack).
+			WillReturnRows(mock.NewRows([]string{"count"}).AddRow(revised))
+		mock.ExpectQuery("^SELECT count\\(\\*\\) FROM adjust_run_factors c LEFT JOIN eod_adjustment_factors f (.+) WHERE c.run_id = (.+) AND \\(f.split_factor IS DISTINCT FROM c.split_factor OR f.dividend_factor IS DISTINCT FROM c.dividend_factor\\)$").
+			WithArgs(int64(7)).
+			WillReturnRows(mock.NewRows([]string{"count"}).AddRow(factorsRevised))
+	}
+
+	It("should record the values overwritten by a run", func() {
+		prices := []*eod.Eod{
+			{CompositeFigi: "TEST", EventDate: day1, AdjClose: 10.0, CumSplitFactor: 1, CumDividendFactor: 1},
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("^CREATE TEMPORARY TABLE eod_adj_close_staging").WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+		mock.ExpectCopyFrom(`"eod_adj_close_staging"`, []string{"composite_figi", "event_date", "adj_close", "adj_open", "adj_high", "adj_low", "adj_volume", "split_adj_close", "net_adj_close", "split_factor", "dividend_factor"}).WillReturnResult(1)
+		mock.ExpectExec("^INSERT INTO adjust_run_changes \\(run_id, composite_figi, event_date, previous_adj_close, adj_close, (.+), previous_net_adj_close, net_adj_close\\) SELECT DISTINCT ON (.+) FROM eod JOIN eod_adj_close_staging s (.+) WHERE eod.adj_close IS DISTINCT FROM s.adj_close OR (.+) ON CONFLICT (.+) DO UPDATE SET (.+)$").
+			WithArgs(int64(7)).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		mock.ExpectExec("^UPDATE eod SET adj_close = s.adj_close, (.+) FROM eod_adj_close_staging s").WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+		mock.ExpectExec("^INSERT INTO adjust_run_factors (.+) FROM \\(SELECT (.+) FROM eod_adjustment_factors WHERE composite_figi = ANY\\(\\$1\\)\\) f FULL JOIN eod_adj_close_staging s (.+) ON CONFLICT (.+)$").
+			WithArgs([]string{"TEST"}, int64(7)).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		mock.ExpectQuery("^DELETE FROM eod_adjustment_factors").WithArgs("TEST").WillReturnRows(mock.NewRows([]string{"event_date", "split_factor", "dividend_factor"}))
+		mock.ExpectCopyFrom(`"eod_adjustment_factors"`, []string{"composite_figi", "event_date", "split_factor", "dividend_factor"}).WillReturnResult(1)
+		mock.ExpectExec("^INSERT INTO eod_adj_close_history").
+			WithArgs("TEST", pgtype.Int8{Int: 7, Status: pgtype.Present}, pgtype.Text{String: "initial adjustment (adjust all)", Status: pgtype.Present}).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		mock.ExpectCommit()
+
+		Expect(eod.SaveAdjCloseRevision(ctx, mock, prices, &eod.Revision{RunID: 7, Reason: "adjust all"})).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should restore the previous values", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery("^SELECT status FROM adjust_runs WHERE run_id = (.+) FOR UPDATE$").
+			WithArgs(int64(7)).
+			WillReturnRows(mock.NewRows([]string{"status"}).AddRow(eod.RunSuccess))
+		expectCount(2)
+		expectRevised(0, 0)
+		mock.ExpectExec("^UPDATE eod e SET adj_close = c.previous_adj_close, adj_open = c.previous_adj_open, (.+), split_adj_close = c.previous_split_adj_close, net_adj_close = c.previous_net_adj_close FROM adjust_run_changes c").
+			WithArgs(int64(7)).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 2))
+		mock.ExpectExec("^DELETE FROM eod_adjustment_factors f USING adjust_run_factors c (.+) AND c.previous_split_factor IS NULL$").
+			WithArgs(int64(7)).
+			WillReturnResult(pgxmock.NewResult("DELETE", 0))
+		mock.ExpectExec("^INSERT INTO eod_adjustment_factors (.+) SELECT composite_figi, event_date, previous_split_factor, previous_dividend_factor FROM adjust_run_factors (.+) ON CONFLICT").
+			WithArgs(int64(7)).
+			WillReturnResult(pgxmock.NewResult("INSERT", 2))
+		mock.ExpectExec("^INSERT INTO eod_adj_close_history").
+			WithArgs(int64(7), "rollback of run 7").
+			WillReturnResult(pgxmock.NewResult("INSERT", 2))
+		mock.ExpectExec("^UPDATE adjust_runs SET status").
+			WithArgs(eod.RunRolledBack, int64(7)).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+		mock.ExpectCommit()
+
+		restored, err := eod.RollbackRun(ctx, mock, 7)
+		Expect(err).To(BeNil())
+		Expect(restored).To(Equal(int64(2)))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should refuse if quotes changed since the run", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery("^SELECT status FROM adjust_runs").
+			WithArgs(int64(7)).
+			WillReturnRows(mock.NewRows([]string{"status"}).AddRow(eod.RunSuccess))
+		expectCount(2)
+		expectRevised(1, 0)
+		mock.ExpectRollback()
+
+		_, err := eod.RollbackRun(ctx, mock, 7)
+		Expect(err).To(MatchError(eod.ErrRunChanged))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should refuse if adjustment factors changed since the run", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery("^SELECT status FROM adjust_runs").
+			WithArgs(int64(7)).
+			WillReturnRows(mock.NewRows([]string{"status"}).AddRow(eod.RunSuccess))
+		expectCount(2)
+		expectRevised(0, 3)
+		mock.ExpectRollback()
+
+		_, err := eod.RollbackRun(ctx, mock, 7)
+		Expect(err).To(MatchError(eod.ErrRunChanged))
+		Expect(err.Error()).To(ContainSubstring("3 adjustment factors"))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should refuse runs that were already rolled back", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery("^SELECT status FROM adjust_runs").
+			WithArgs(int64(7)).
+			WillReturnRows(mock.NewRows([]string{"status"}).AddRow(eod.RunRolledBack))
+		mock.ExpectRollback()
+
+		_, err := eod.RollbackRun(ctx, mock, 7)
+		Expect(err).To(MatchError(eod.ErrRunAlreadyUndone))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should report unknown runs", func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery("^SELECT status FROM adjust_runs").
+			WithArgs(int64(7)).
+			WillReturnError(pgx.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err := eod.RollbackRun(ctx, mock, 7)
+		Expect(err).To(MatchError(eod.ErrUnknownRun))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
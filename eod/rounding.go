@@ -0,0 +1,128 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/jackc/pgtype"
+)
+
+var (
+	ErrUnknownRoundingMode = errors.New("unknown rounding mode")
+)
+
+// RoundingMode controls how adjusted prices are rounded before they are
+// written to the database
+type RoundingMode string
+
+const (
+	// RoundNone writes prices at full float64 precision
+	RoundNone RoundingMode = "none"
+
+	// RoundHalfEven rounds to the nearest value, ties to even (banker's
+	// rounding)
+	RoundHalfEven RoundingMode = "half-even"
+
+	// RoundHalfUp rounds to the nearest value, ties away from zero
+	RoundHalfUp RoundingMode = "half-up"
+
+	// RoundTruncate rounds towards zero
+	RoundTruncate RoundingMode = "truncate"
+)
+
+// ParseRoundingMode returns the RoundingMode with the given name
+func ParseRoundingMode(name string) (RoundingMode, error) {
+	switch RoundingMode(name) {
+	case RoundNone, RoundHalfEven, RoundHalfUp, RoundTruncate:
+		return RoundingMode(name), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownRoundingMode, name)
+	}
+}
+
+// RoundingPolicy rounds prices to Places decimal places using Mode; the zero
+// value leaves prices unrounded
+type RoundingPolicy struct {
+	Mode   RoundingMode
+	Places int
+}
+
+// Round returns price rounded by the policy. Rounding is done on the exact
+// binary value of price so ties are only broken for prices that are exactly
+// half way between two decimals.
+func (policy RoundingPolicy) Round(price float64) float64 {
+	if policy.Mode == "" || policy.Mode == RoundNone || math.IsNaN(price) || math.IsInf(price, 0) {
+		return price
+	}
+
+	// a float64 mantissa times a power of ten fits in BigFloatPrecision
+	// bits so scaled, whole and frac are exact
+	scale := new(big.Float).SetPrec(BigFloatPrecision).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(policy.Places)), nil))
+	scaled := newBigFloat(price)
+	scaled.Mul(scaled, scale)
+
+	whole, _ := scaled.Int(nil)
+	frac := new(big.Float).SetPrec(BigFloatPrecision).SetInt(whole)
+	frac.Sub(scaled, frac)
+
+	// away is +1 or -1, the direction that moves whole away from zero
+	away := big.NewInt(int64(scaled.Sign()))
+	half := big.NewFloat(.5)
+	cmp := new(big.Float).Abs(frac).Cmp(half)
+
+	switch policy.Mode {
+	case RoundHalfUp:
+		if cmp >= 0 {
+			whole.Add(whole, away)
+		}
+	case RoundHalfEven:
+		if cmp > 0 || (cmp == 0 && whole.Bit(0) == 1) {
+			whole.Add(whole, away)
+		}
+	}
+
+	rounded := new(big.Float).SetPrec(BigFloatPrecision).SetInt(whole)
+	result, _ := rounded.Quo(rounded, scale).Float64()
+	return result
+}
+
+// roundFloat8 rounds val, preserving NULL values
+func (policy RoundingPolicy) roundFloat8(val pgtype.Float8) pgtype.Float8 {
+	if val.Status != pgtype.Present {
+		return val
+	}
+	return pgtype.Float8{Float: policy.Round(val.Float), Status: pgtype.Present}
+}
+
+// RoundPrices rounds the adjusted prices of every quote in place. Volumes are
+// not rounded.
+func RoundPrices(prices []*Eod, policy RoundingPolicy) {
+	if policy.Mode == "" || policy.Mode == RoundNone {
+		return
+	}
+
+	for _, myEod := range prices {
+		myEod.AdjClose = policy.Round(myEod.AdjClose)
+		myEod.SplitAdjClose = policy.Round(myEod.SplitAdjClose)
+		myEod.NetAdjClose = policy.Round(myEod.NetAdjClose)
+		myEod.AdjOpen = policy.roundFloat8(myEod.AdjOpen)
+		myEod.AdjHigh = policy.roundFloat8(myEod.AdjHigh)
+		myEod.AdjLow = policy.roundFloat8(myEod.AdjLow)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Run scopes recorded in adjust_runs; only runs that cover every changed
+// asset advance the watermark
+const (
+	ScopeAll    = "all"
+	ScopeRecent = "recent"
+	ScopeClean  = "clean"
+	ScopeAssets = "assets"
+	ScopeSince  = "since"
+)
+
+// Run statuses recorded in adjust_runs
+const (
+	RunRunning = "running"
+	RunSuccess = "success"
+	RunFailed  = "failed"
+)
+
+// StartRun records the start of an adjust run and returns its id. since is
+// the watermark the run selected assets from and may be the zero time.
+func StartRun(ctx context.Context, conn PgxIface, scope string, since time.Time) (int64, error) {
+	var runID int64
+	var sinceArg interface{}
+	if !since.IsZero() {
+		sinceArg = since
+	}
+
+	if err := conn.QueryRow(ctx, `INSERT INTO adjust_runs (scope, since) VALUES ($1, $2) RETURNING run_id`, scope, sinceArg).Scan(&runID); err != nil {
+		log.Error().Err(err).Str("Scope", scope).Msg("could not record start of adjust run")
+		return 0, err
+	}
+
+	return runID, nil
+}
+
+// FinishRun records the outcome of an adjust run. A run is successful when
+// no assets failed.
+func FinishRun(ctx context.Context, conn PgxIface, runID int64, summary *AdjustSummary) error {
+	status := RunSuccess
+	if len(summary.Failed) > 0 {
+		status = RunFailed
+	}
+
+	numAssets := len(summary.Succeeded) + len(summary.Failed)
+	if _, err := conn.Exec(ctx, `UPDATE adjust_runs SET finished_at = now(), status = $1, num_assets = $2, num_failed = $3 WHERE run_id = $4`, status, numAssets, len(summary.Failed), runID); err != nil {
+		log.Error().Err(err).Int64("RunID", runID).Msg("could not record end of adjust run")
+		return err
+	}
+
+	return nil
+}
+
+// LoadWatermark returns the start time of the most recent successful run that
+// covered every changed asset. ok is false if there has never been one.
+func LoadWatermark(ctx context.Context, conn PgxIface) (watermark time.Time, ok bool, err error) {
+	err = conn.QueryRow(ctx, `SELECT started_at FROM adjust_runs WHERE status = $1 AND scope IN ($2, $3) ORDER BY started_at DESC LIMIT 1`, RunSuccess, ScopeRecent, ScopeAll).Scan(&watermark)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return watermark, false, nil
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("could not load adjust watermark")
+		return watermark, false, err
+	}
+	return watermark, true, nil
+}
+
+// AssetsWithActionsSince returns the composite figi of every asset with a
+// corporate action in eod or corporate_actions that was inserted, changed or
+// deleted at or after since, or whose ex-date is at or after since
+func AssetsWithActionsSince(ctx context.Context, conn PgxIface, since time.Time) ([]string, error) {
+	assets := make([]string, 0)
+
+	rows, err := conn.Query(ctx, `SELECT DISTINCT composite_figi FROM eod WHERE actions_updated_at >= $1 OR (event_date >= $1 AND (split_factor != 1.0 OR dividend > 0.0)) UNION SELECT composite_figi FROM corporate_actions WHERE updated_at >= $1 OR ex_date >= $1 UNION SELECT composite_figi FROM corporate_actions_deleted WHERE deleted_at >= $1`, since)
+	if err != nil {
+		log.Error().Err(err).Time("Since", since).Msg("could not query assets with recent corporate actions")
+		return assets, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var figi string
+		if err := rows.Scan(&figi); err != nil {
+			log.Error().Err(err).Msg("could not scan composite_figi into variable")
+			return assets, err
+		}
+		assets = append(assets, figi)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Time("Since", since).Msg("could not read assets with recent corporate actions")
+		return assets, err
+	}
+
+	return assets, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("adjust runs", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	It("should return the start of the last successful run as the watermark", func() {
+		started := time.Date(2021, 1, 4, 22, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("^SELECT started_at FROM adjust_runs WHERE status = (.+)").
+			WithArgs(eod.RunSuccess, eod.ScopeRecent, eod.ScopeAll).
+			WillReturnRows(mock.NewRows([]string{"started_at"}).AddRow(started))
+
+		watermark, ok, err := eod.LoadWatermark(ctx, mock)
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeTrue())
+		Expect(watermark).To(Equal(started))
+	})
+
+	It("should report when there is no watermark", func() {
+		mock.ExpectQuery("^SELECT started_at FROM adjust_runs").WillReturnError(pgx.ErrNoRows)
+
+		_, ok, err := eod.LoadWatermark(ctx, mock)
+		Expect(err).To(BeNil())
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should select assets with corporate actions changed since the watermark", func() {
+		since := time.Date(2021, 1, 4, 22, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("^SELECT DISTINCT composite_figi FROM eod WHERE actions_updated_at >= (.+) UNION SELECT composite_figi FROM corporate_actions_deleted WHERE deleted_at >= (.+)$").
+			WithArgs(since).
+			WillReturnRows(mock.NewRows([]string{"composite_figi"}).AddRow("AAA").AddRow("BBB"))
+
+		assets, err := eod.AssetsWithActionsSince(ctx, mock, since)
+		Expect(err).To(BeNil())
+		Expect(assets).To(Equal([]string{"AAA", "BBB"}))
+	})
+
+	It("should report errors reading the selected assets", func() {
+		since := time.Date(2021, 1, 4, 22, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("^SELECT DISTINCT composite_figi FROM eod").
+			WithArgs(since).
+			WillReturnRows(mock.NewRows([]string{"composite_figi"}).AddRow("AAA").AddRow("BBB").RowError(1, errors.New("connection reset")))
+
+		_, err := eod.AssetsWithActionsSince(ctx, mock, since)
+		Expect(err).To(MatchError("connection reset"))
+	})
+
+	It("should only mark runs without failures as successful", func() {
+		mock.ExpectQuery("^INSERT INTO adjust_runs").WithArgs(eod.ScopeRecent, pgxmock.AnyArg()).
+			WillReturnRows(mock.NewRows([]string{"run_id"}).AddRow(int64(7)))
+		mock.ExpectExec("^UPDATE adjust_runs SET finished_at = now()").WithArgs(eod.RunFailed, 2, 1, int64(7)).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+		runID, err := eod.StartRun(ctx, mock, eod.ScopeRecent, time.Now())
+		Expect(err).To(BeNil())
+		Expect(runID).To(Equal(int64(7)))
+
+		summary := &eod.AdjustSummary{
+			Succeeded: []string{"AAA"},
+			Failed:    map[string]error{"BBB": errors.New("failed")},
+		}
+		Expect(eod.FinishRun(ctx, mock, runID, summary)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
@@ -0,0 +1,114 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgtype"
+)
+
+var (
+	ErrUnknownSeries     = errors.New("unknown adjusted price series")
+	ErrUnsupportedSeries = errors.New("adjusted price series is only calculated with the crsp method")
+)
+
+// Series identifies an adjusted price series stored in the eod table
+type Series string
+
+const (
+	// TotalReturnSeries is adjusted for both splits and dividends (adj_close
+	// along with adj_open, adj_high, adj_low and the split adjusted adj_volume)
+	TotalReturnSeries Series = "total"
+
+	// SplitSeries is adjusted with the price factor only: splits, stock
+	// dividends, spin-offs and rights but not cash dividends (split_adj_close)
+	SplitSeries Series = "split"
+
+	// NetTotalReturnSeries is the CRSP close adjusted for splits and
+	// dividends net of withholding tax (net_adj_close)
+	NetTotalReturnSeries Series = "net"
+)
+
+// AllSeries lists every Series
+var AllSeries = []Series{TotalReturnSeries, SplitSeries, NetTotalReturnSeries}
+
+// ParseSeries converts a series name into a Series
+func ParseSeries(name string) (Series, error) {
+	switch Series(name) {
+	case TotalReturnSeries, SplitSeries, NetTotalReturnSeries:
+		return Series(name), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownSeries, name)
+	}
+}
+
+// CheckSeries returns an error if opts selects a series that opts.Adjuster
+// does not calculate. The split and net series are always CRSP adjusted, so
+// only the total return series can be written with another method.
+func CheckSeries(opts *AdjustOptions) error {
+	if _, ok := opts.Adjuster.(*CRSPAdjuster); opts.Adjuster == nil || ok {
+		return nil
+	}
+	for _, s := range opts.Series {
+		if s != TotalReturnSeries {
+			return fmt.Errorf("%w: %s", ErrUnsupportedSeries, s)
+		}
+	}
+	return nil
+}
+
+// Columns returns the eod columns the series is stored in
+func (s Series) Columns() []string {
+	switch s {
+	case SplitSeries:
+		return []string{"split_adj_close"}
+	case NetTotalReturnSeries:
+		return []string{"net_adj_close"}
+	default:
+		return []string{"adj_close", "adj_open", "adj_high", "adj_low", "adj_volume"}
+	}
+}
+
+// Values returns the values of the series for the eod quote in the same order
+// as Columns
+func (s Series) Values(myEod *Eod) []interface{} {
+	switch s {
+	case SplitSeries:
+		return []interface{}{adjustedClose(myEod, myEod.SplitAdjClose)}
+	case NetTotalReturnSeries:
+		return []interface{}{adjustedClose(myEod, myEod.NetAdjClose)}
+	default:
+		return []interface{}{adjustedClose(myEod, myEod.AdjClose), nullIfUndefined(myEod.AdjOpen), nullIfUndefined(myEod.AdjHigh), nullIfUndefined(myEod.AdjLow), nullIfUndefined(myEod.AdjVolume)}
+	}
+}
+
+// adjustedClose returns val, or NULL when the raw close of the quote is NULL
+func adjustedClose(myEod *Eod, val float64) interface{} {
+	if myEod.MissingClose {
+		return pgtype.Float8{Status: pgtype.Null}
+	}
+	return val
+}
+
+// nullIfUndefined treats values that were never set as NULL so they can be
+// encoded for the database
+func nullIfUndefined(val pgtype.Float8) pgtype.Float8 {
+	if val.Status == pgtype.Undefined {
+		return pgtype.Float8{Status: pgtype.Null}
+	}
+	return val
+}
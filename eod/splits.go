@@ -0,0 +1,226 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// CommonSplitRatios are the split factors DetectSplits matches price jumps
+// against. A 2:1 split has a factor of 2 and halves the price; a 1:10
+// reverse split has a factor of 0.1. 3:2 is the only fractional ratio common
+// enough to include; rarer ones such as 5:4 and 4:3 are left out because they
+// can't be told apart from a large one-day move.
+var CommonSplitRatios = []float64{
+	3.0 / 2, 2, 3, 4, 5, 8, 10, 20,
+	2.0 / 3, 1.0 / 2, 1.0 / 3, 1.0 / 4, 1.0 / 5, 1.0 / 8, 1.0 / 10, 1.0 / 20,
+}
+
+// DefaultSplitTolerance is the maximum relative difference between an
+// observed price ratio and a split ratio for the jump to be treated as a split
+const DefaultSplitTolerance = .02
+
+// SplitConfirmationDays is the number of quotes after a price jump that must
+// stay at the new price level for a proposed split to be confirmed; only
+// confirmed proposals are applied
+var SplitConfirmationDays = 5
+
+// SplitProposal is a split inferred from a price jump on a day without a
+// recorded split
+type SplitProposal struct {
+	CompositeFigi       string
+	Ticker              string
+	EventDate           time.Time
+	PrevClose           float64
+	Close               float64
+	OldSplitFactor      float64
+	ProposedSplitFactor float64
+
+	// Confirmed is set when the price did not move back towards the level
+	// before the jump over the following SplitConfirmationDays quotes
+	Confirmed bool
+}
+
+// DetectSplits looks for day-over-day close ratios within tolerance of one of
+// CommonSplitRatios on days with a split factor of 1 and proposes the matching
+// split factor. The previous close is reduced by the dividend going ex on the
+// day before the ratio is calculated. A tolerance of 0 uses
+// DefaultSplitTolerance.
+func DetectSplits(ctx context.Context, conn PgxIface, compositeFigi string, tolerance float64) ([]*SplitProposal, error) {
+	if tolerance <= 0 {
+		tolerance = DefaultSplitTolerance
+	}
+
+	rows, err := conn.Query(ctx, "SELECT event_date, ticker, close, dividend, split_factor FROM eod WHERE composite_figi = $1 ORDER BY event_date, "+tickerInEffect+", ticker", compositeFigi)
+	if err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not query eod quotes to detect splits")
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotes := make([]*Eod, 0)
+	for rows.Next() {
+		myEod := &Eod{}
+		var closePrice pgtype.Float8
+		if err := rows.Scan(&myEod.EventDate, &myEod.Ticker, &closePrice, &myEod.Dividend, &myEod.SplitFactor); err != nil {
+			log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not scan eod quote to detect splits")
+			return nil, err
+		}
+		// missing closes are treated as 0 and never match a split
+		myEod.Close = closePrice.Float
+
+		if len(quotes) > 0 && myEod.EventDate.Equal(quotes[len(quotes)-1].EventDate) {
+			continue
+		}
+		quotes = append(quotes, myEod)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not read eod quotes to detect splits")
+		return nil, err
+	}
+
+	proposals := make([]*SplitProposal, 0)
+	for idx := 1; idx < len(quotes); idx++ {
+		prev, myEod := quotes[idx-1], quotes[idx]
+		exDividendClose := prev.Close - myEod.Dividend
+		if exDividendClose <= 0 || myEod.Close <= 0 || myEod.SplitFactor != 1 {
+			continue
+		}
+
+		if ratio, ok := matchSplitRatio(exDividendClose/myEod.Close, tolerance); ok {
+			proposals = append(proposals, &SplitProposal{
+				CompositeFigi:       compositeFigi,
+				Ticker:              myEod.Ticker,
+				EventDate:           myEod.EventDate,
+				PrevClose:           prev.Close,
+				Close:               myEod.Close,
+				OldSplitFactor:      myEod.SplitFactor,
+				ProposedSplitFactor: ratio,
+				Confirmed:           splitPersists(quotes[idx:], ratio),
+			})
+		}
+	}
+
+	return proposals, nil
+}
+
+// splitPersists returns true if the SplitConfirmationDays quotes after the
+// first quote in quotes, adjusted for the splits recorded after it, stay
+// closer to its close than to the close before a split of ratio
+func splitPersists(quotes []*Eod, ratio float64) bool {
+	if len(quotes) <= SplitConfirmationDays {
+		return false
+	}
+
+	limit := math.Abs(math.Log(ratio)) / 2
+	cumSplitFactor := 1.0
+	for _, quote := range quotes[1 : SplitConfirmationDays+1] {
+		if quote.SplitFactor > 0 {
+			cumSplitFactor *= quote.SplitFactor
+		}
+		if quote.Close <= 0 || math.Abs(math.Log(quote.Close*cumSplitFactor/quotes[0].Close)) >= limit {
+			return false
+		}
+	}
+	return true
+}
+
+// matchSplitRatio returns the split ratio closest to observed if it is within
+// tolerance
+func matchSplitRatio(observed, tolerance float64) (float64, bool) {
+	best := 0.0
+	bestDiff := math.Inf(1)
+	for _, ratio := range CommonSplitRatios {
+		diff := math.Abs(observed/ratio - 1)
+		if diff < bestDiff {
+			best = ratio
+			bestDiff = diff
+		}
+	}
+	return best, bestDiff <= tolerance
+}
+
+// SaveSplitProposals records proposals in split_inference_audit; a proposal
+// already recorded by an earlier run is not recorded again. When apply is set
+// the confirmed proposals are also written to eod in the same transaction;
+// rows whose split factor changed since detection are left alone and recorded
+// as not applied.
+func SaveSplitProposals(ctx context.Context, conn PgxIface, proposals []*SplitProposal, apply bool) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("could not begin db transaction to save split proposals")
+		return err
+	}
+
+	if err := saveSplitProposals(ctx, tx, proposals, apply); err != nil {
+		if err2 := tx.Rollback(ctx); err2 != nil {
+			log.Error().Err(err2).Msg("failed to rollback db transaction")
+		}
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msg("could not commit split proposals to database")
+		return err
+	}
+
+	return nil
+}
+
+func saveSplitProposals(ctx context.Context, tx pgx.Tx, proposals []*SplitProposal, apply bool) error {
+	for _, proposal := range proposals {
+		applied := false
+		if apply && proposal.Confirmed {
+			tag, err := tx.Exec(ctx, `UPDATE eod SET split_factor = $1 WHERE composite_figi = $2 AND event_date = $3 AND split_factor = $4`,
+				proposal.ProposedSplitFactor, proposal.CompositeFigi, proposal.EventDate, proposal.OldSplitFactor)
+			if err != nil {
+				log.Error().Err(err).Str("CompositeFigi", proposal.CompositeFigi).Time("EventDate", proposal.EventDate).Msg("could not apply inferred split")
+				return err
+			}
+			applied = tag.RowsAffected() > 0
+		}
+
+		if _, err := tx.Exec(ctx, `INSERT INTO split_inference_audit (composite_figi, event_date, ticker, prev_close, close, old_split_factor, proposed_split_factor, confirmed, applied) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (composite_figi, event_date, proposed_split_factor) DO UPDATE SET confirmed = split_inference_audit.confirmed OR EXCLUDED.confirmed, applied = split_inference_audit.applied OR EXCLUDED.applied
+WHERE (EXCLUDED.confirmed AND NOT split_inference_audit.confirmed) OR (EXCLUDED.applied AND NOT split_inference_audit.applied)`,
+			proposal.CompositeFigi, proposal.EventDate, proposal.Ticker, proposal.PrevClose, proposal.Close, proposal.OldSplitFactor, proposal.ProposedSplitFactor, proposal.Confirmed, applied); err != nil {
+			log.Error().Err(err).Str("CompositeFigi", proposal.CompositeFigi).Time("EventDate", proposal.EventDate).Msg("could not save split proposal")
+			return err
+		}
+
+		log.Info().Str("CompositeFigi", proposal.CompositeFigi).Time("EventDate", proposal.EventDate).Float64("SplitFactor", proposal.ProposedSplitFactor).Bool("Confirmed", proposal.Confirmed).Bool("Applied", applied).Msg("inferred split")
+	}
+
+	return nil
+}
+
+// PrintSplitProposals writes a table of proposals to w
+func PrintSplitProposals(w io.Writer, proposals []*SplitProposal) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CompositeFigi\tTicker\tDate\tPrevClose\tClose\tSplitFactor\tConfirmed")
+	for _, proposal := range proposals {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.4f\t%.4f\t%.4f\t%t\n", proposal.CompositeFigi, proposal.Ticker, proposal.EventDate.Format("2006-01-02"), proposal.PrevClose, proposal.Close, proposal.ProposedSplitFactor, proposal.Confirmed)
+	}
+	tw.Flush()
+}
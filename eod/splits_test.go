@@ -0,0 +1,191 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("split inference", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+	)
+
+	day := func(d int) time.Time {
+		return time.Date(2021, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+		eod.SplitConfirmationDays = 3
+
+		// unrecorded 2:1 split on day 2, recorded 3:1 split on day 3, an
+		// unrecorded 1:10 reverse split on day 6, a halving on day 8 that
+		// reverts the next day and a distribution of half the price on day 10
+		rows := mock.NewRows([]string{"event_date", "ticker", "close", "dividend", "split_factor"}).
+			AddRow(day(1), "TEST", 100.0, 0.0, 1.0).
+			AddRow(day(2), "TEST", 50.5, 0.0, 1.0).
+			AddRow(day(3), "TEST", 16.8, 0.0, 3.0).
+			AddRow(day(4), "TEST", 17.0, 0.0, 1.0).
+			AddRow(day(5), "TEST", 17.2, 0.0, 1.0).
+			AddRow(day(6), "TEST", 172.0, 0.0, 1.0).
+			AddRow(day(7), "TEST", 150.0, 0.0, 1.0).
+			AddRow(day(8), "TEST", 75.0, 0.0, 1.0).
+			AddRow(day(9), "TEST", 140.0, 0.0, 1.0).
+			AddRow(day(10), "TEST", 70.0, 70.0, 1.0)
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date, (.+), ticker$").WithArgs("TEST").WillReturnRows(rows)
+	})
+
+	AfterEach(func() {
+		eod.SplitConfirmationDays = 5
+		mock.Close(ctx)
+	})
+
+	It("should propose splits for jumps matching common ratios", func() {
+		proposals, err := eod.DetectSplits(ctx, mock, "TEST", 0)
+		Expect(err).To(BeNil())
+		Expect(proposals).To(HaveLen(3))
+
+		Expect(proposals[0].EventDate).To(Equal(day(2)))
+		Expect(proposals[0].ProposedSplitFactor).To(Equal(2.0))
+		Expect(proposals[0].PrevClose).To(Equal(100.0))
+		Expect(proposals[0].Confirmed).To(BeTrue())
+
+		Expect(proposals[1].EventDate).To(Equal(day(6)))
+		Expect(proposals[1].ProposedSplitFactor).To(BeNumerically("~", .1))
+		Expect(proposals[1].Confirmed).To(BeTrue())
+
+		Expect(proposals[2].EventDate).To(Equal(day(8)))
+		Expect(proposals[2].Confirmed).To(BeFalse())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should not confirm a jump without enough later quotes", func() {
+		eod.SplitConfirmationDays = 10
+
+		proposals, err := eod.DetectSplits(ctx, mock, "TEST", 0)
+		Expect(err).To(BeNil())
+		Expect(proposals).To(HaveLen(3))
+		for _, proposal := range proposals {
+			Expect(proposal.Confirmed).To(BeFalse())
+		}
+	})
+
+	It("should only record proposals without --apply", func() {
+		proposals, err := eod.DetectSplits(ctx, mock, "TEST", 0)
+		Expect(err).To(BeNil())
+
+		mock.ExpectBegin()
+		for _, proposal := range proposals {
+			mock.ExpectExec("^INSERT INTO split_inference_audit (.+) ON CONFLICT (.+) DO UPDATE").
+				WithArgs("TEST", proposal.EventDate, "TEST", proposal.PrevClose, proposal.Close, 1.0, proposal.ProposedSplitFactor, proposal.Confirmed, false).
+				WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		}
+		mock.ExpectCommit()
+
+		Expect(eod.SaveSplitProposals(ctx, mock, proposals, false)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should update split_factor and audit applied proposals", func() {
+		proposals, err := eod.DetectSplits(ctx, mock, "TEST", 0)
+		Expect(err).To(BeNil())
+
+		mock.ExpectBegin()
+		mock.ExpectExec("^UPDATE eod SET split_factor").
+			WithArgs(2.0, "TEST", day(2), 1.0).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+		mock.ExpectExec("^INSERT INTO split_inference_audit").
+			WithArgs("TEST", day(2), "TEST", 100.0, 50.5, 1.0, 2.0, true, true).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		// the reverse split was changed by someone else since detection
+		mock.ExpectExec("^UPDATE eod SET split_factor").
+			WithArgs(proposals[1].ProposedSplitFactor, "TEST", day(6), 1.0).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+		mock.ExpectExec("^INSERT INTO split_inference_audit").
+			WithArgs("TEST", day(6), "TEST", 17.2, 172.0, 1.0, proposals[1].ProposedSplitFactor, true, false).
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		// unconfirmed proposals are only recorded
+		mock.ExpectExec("^INSERT INTO split_inference_audit").
+			WithArgs("TEST", day(8), "TEST", 150.0, 75.0, 1.0, 2.0, false, false).
+			WillReturnResult(pgxmock.NewResult("INSERT", 0))
+		mock.ExpectCommit()
+
+		Expect(eod.SaveSplitProposals(ctx, mock, proposals, true)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
+
+var _ = Describe("split inference of 3:2 splits", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+	)
+
+	day := func(d int) time.Time {
+		return time.Date(2021, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+		eod.SplitConfirmationDays = 3
+	})
+
+	AfterEach(func() {
+		eod.SplitConfirmationDays = 5
+		mock.Close(ctx)
+	})
+
+	It("should propose 3:2 and 2:3 splits", func() {
+		// unrecorded 3:2 split on day 2 and 2:3 reverse split on day 6
+		rows := mock.NewRows([]string{"event_date", "ticker", "close", "dividend", "split_factor"}).
+			AddRow(day(1), "TEST", 90.0, 0.0, 1.0).
+			AddRow(day(2), "TEST", 60.3, 0.0, 1.0).
+			AddRow(day(3), "TEST", 60.0, 0.0, 1.0).
+			AddRow(day(4), "TEST", 59.5, 0.0, 1.0).
+			AddRow(day(5), "TEST", 60.1, 0.0, 1.0).
+			AddRow(day(6), "TEST", 90.5, 0.0, 1.0).
+			AddRow(day(7), "TEST", 90.0, 0.0, 1.0).
+			AddRow(day(8), "TEST", 91.0, 0.0, 1.0).
+			AddRow(day(9), "TEST", 90.2, 0.0, 1.0)
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date, (.+), ticker$").WithArgs("TEST").WillReturnRows(rows)
+
+		proposals, err := eod.DetectSplits(ctx, mock, "TEST", 0)
+		Expect(err).To(BeNil())
+		Expect(proposals).To(HaveLen(2))
+
+		Expect(proposals[0].EventDate).To(Equal(day(2)))
+		Expect(proposals[0].ProposedSplitFactor).To(Equal(1.5))
+		Expect(proposals[0].Confirmed).To(BeTrue())
+
+		Expect(proposals[1].EventDate).To(Equal(day(6)))
+		Expect(proposals[1].ProposedSplitFactor).To(BeNumerically("~", 2.0/3))
+		Expect(proposals[1].Confirmed).To(BeTrue())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
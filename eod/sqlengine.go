@@ -0,0 +1,466 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrUnknownEngine        = errors.New("unknown adjust engine")
+	ErrUnsupportedBySQL     = errors.New("option is not supported by the sql engine")
+	ErrSQLEngineBatchFailed = errors.New("sql engine batch failed")
+)
+
+// Engine selects where adjusted prices are calculated
+type Engine string
+
+const (
+	// GoEngine loads each asset's quotes and calculates prices in Go
+	GoEngine Engine = "go"
+
+	// SQLEngine calculates CRSP prices inside PostgreSQL with window
+	// functions, one statement per batch of assets. It only supports the
+	// default options and the carry zero price policy.
+	SQLEngine Engine = "sql"
+)
+
+// DefaultSQLBatchSize is the number of assets adjusted per statement by the
+// sql engine when AdjustOptions.BatchSize is not set
+var DefaultSQLBatchSize = 500
+
+// ParseEngine returns the Engine with the given name
+func ParseEngine(name string) (Engine, error) {
+	switch Engine(name) {
+	case GoEngine, SQLEngine:
+		return Engine(name), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownEngine, name)
+	}
+}
+
+// sqlAdjustedPrices computes the CRSP adjusted prices of the assets in $1 as
+// the CTE adjusted. The cumulative factors are reverse running products over
+// the quotes after each date, calculated as exp(sum(ln(...))). Like the Go
+// engine, the quote of the ticker in effect is used when tickers overlap
+// (taking a dividend or split recorded only on a duplicate), dividends on
+// quotes without a positive close are dropped, a NULL close has a NULL
+// adjusted close and, when $2 is true, the delisting return is folded into
+// the final adjusted close. Split factors and dividends that would give a
+// zero or negative factor are left out so ln() cannot fail the batch and a
+// delisting without a usable return is folded in as 0; the assets holding
+// either are removed from the batch beforehand by invalidAssetsSQL.
+const sqlAdjustedPrices = `WITH quotes AS (
+	SELECT DISTINCT ON (composite_figi, event_date) composite_figi, event_date, ticker, close, open, high, low, volume::double precision AS volume,
+		CASE WHEN dividend <> 0 THEN dividend ELSE COALESCE(max(NULLIF(dividend, 0)) OVER same_day, 0) END AS dividend,
+		CASE WHEN split_factor NOT IN (0, 1) THEN split_factor ELSE COALESCE(max(NULLIF(NULLIF(split_factor, 1), 0)) OVER same_day, split_factor) END AS split_factor
+	FROM eod WHERE composite_figi = ANY($1)
+	WINDOW same_day AS (PARTITION BY composite_figi, event_date)
+	ORDER BY composite_figi, event_date, ` + tickerInEffect + `, ticker
+), factors AS (
+	SELECT composite_figi, event_date, close, open, high, low, volume,
+		exp(COALESCE(SUM(CASE WHEN split_factor > 0 THEN ln(split_factor) END) OVER later, 0)) AS split_factor,
+		exp(COALESCE(SUM(CASE WHEN close > 0 AND dividend > -close THEN ln(1 + dividend / close) ELSE 0 END) OVER later, 0)) AS dividend_factor,
+		row_number() OVER (PARTITION BY composite_figi ORDER BY event_date DESC) AS age
+	FROM quotes
+	WINDOW later AS (PARTITION BY composite_figi ORDER BY event_date DESC ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING)
+), adjusted AS (
+	SELECT f.composite_figi, f.event_date, f.split_factor, f.dividend_factor,
+		f.close / (f.split_factor * f.dividend_factor) * CASE WHEN f.age = 1 AND $2 THEN 1 + COALESCE(d.delisting_return, CASE WHEN f.close > 0 THEN d.final_payment / f.close - 1 END, 0) ELSE 1 END AS adj_close,
+		f.open / (f.split_factor * f.dividend_factor) AS adj_open,
+		f.high / (f.split_factor * f.dividend_factor) AS adj_high,
+		f.low / (f.split_factor * f.dividend_factor) AS adj_low,
+		f.volume * f.split_factor AS adj_volume
+	FROM factors f LEFT JOIN delistings d ON d.composite_figi = f.composite_figi
+) `
+
+// CheckSQLEngine returns an error if opts uses an option the sql engine does
+// not implement
+func CheckSQLEngine(opts *AdjustOptions) error {
+	unsupported := ""
+	if _, ok := opts.Adjuster.(*CRSPAdjuster); opts.Adjuster != nil && !ok {
+		unsupported = "Adjuster"
+	}
+	if opts.ActionSource != "" && opts.ActionSource != EodActions {
+		unsupported = "ActionSource"
+	}
+	if len(opts.ExcludedDividends) > 0 {
+		unsupported = "ExcludedDividends"
+	}
+	if opts.Delisting == DelistingRow {
+		unsupported = "Delisting"
+	}
+	if opts.ZeroPricePolicy != "" && opts.ZeroPricePolicy != CarryFactor {
+		unsupported = "ZeroPricePolicy"
+	}
+	if opts.Arithmetic == BigArithmetic {
+		unsupported = "Arithmetic"
+	}
+	if opts.Rounding.Mode != "" && opts.Rounding.Mode != RoundNone {
+		unsupported = "Rounding"
+	}
+	if !opts.AsOf.IsZero() {
+		unsupported = "AsOf"
+	}
+	if opts.Validate {
+		unsupported = "Validate"
+	}
+	for _, s := range opts.Series {
+		if s != TotalReturnSeries {
+			unsupported = "Series"
+		}
+	}
+
+	if unsupported != "" {
+		return fmt.Errorf("%w: %s", ErrUnsupportedBySQL, unsupported)
+	}
+	return nil
+}
+
+// adjustAssetsSQL adjusts assets with the sql engine in batches of
+// opts.BatchSize. Assets with a split factor, dividend or delisting the Go
+// engine rejects fail on their own; the other assets in a batch fail or succeed
+// together.
+func adjustAssetsSQL(ctx context.Context, conn PgxIface, assets []string, opts *AdjustOptions) *AdjustSummary {
+	summary := &AdjustSummary{
+		Succeeded: make([]string, 0, len(assets)),
+		Failed:    make(map[string]error),
+	}
+
+	if err := CheckSQLEngine(opts); err != nil {
+		for _, compositeFigi := range assets {
+			summary.Failed[compositeFigi] = err
+		}
+		return summary
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize < 1 {
+		batchSize = DefaultSQLBatchSize
+	}
+
+	for start := 0; start < len(assets); start += batchSize {
+		end := start + batchSize
+		if end > len(assets) {
+			end = len(assets)
+		}
+		batch := assets[start:end]
+
+		invalid, err := invalidAssetsSQL(ctx, conn, batch, opts)
+		if err != nil {
+			for _, compositeFigi := range batch {
+				summary.Failed[compositeFigi] = fmt.Errorf("%w: %s", ErrSQLEngineBatchFailed, err)
+			}
+			continue
+		}
+		if len(invalid) > 0 {
+			valid := make([]string, 0, len(batch))
+			for _, compositeFigi := range batch {
+				if assetErr, ok := invalid[compositeFigi]; ok {
+					summary.Failed[compositeFigi] = assetErr
+				} else {
+					valid = append(valid, compositeFigi)
+				}
+			}
+			if batch = valid; len(batch) == 0 {
+				continue
+			}
+		}
+
+		log.Info().Int("BatchStart", start).Int("NumAssets", len(batch)).Msg("adjusting batch of assets in database")
+
+		if opts.DryRun {
+			var diffs []*AdjCloseDiff
+			if diffs, err = diffAdjCloseSQL(ctx, conn, batch, opts); err == nil {
+				summary.Diffs = append(summary.Diffs, diffs...)
+			}
+		} else {
+			err = saveAdjCloseSQL(ctx, conn, batch, opts)
+		}
+
+		if err != nil {
+			for _, compositeFigi := range batch {
+				summary.Failed[compositeFigi] = fmt.Errorf("%w: %s", ErrSQLEngineBatchFailed, err)
+			}
+			continue
+		}
+		summary.Succeeded = append(summary.Succeeded, batch...)
+	}
+
+	sort.Strings(summary.Succeeded)
+	sort.Slice(summary.Diffs, func(i, j int) bool {
+		return summary.Diffs[i].CompositeFigi < summary.Diffs[j].CompositeFigi
+	})
+
+	return summary
+}
+
+// invalidAssetsSQL returns an error for each asset in a batch the Go engine
+// would reject: a split factor or dividend that gives a zero or negative
+// adjustment factor, naming the newest such quote, or, when the delisting
+// return is folded in, a delisting without a return or a final payment and a
+// positive final close
+func invalidAssetsSQL(ctx context.Context, conn PgxIface, figis []string, opts *AdjustOptions) (map[string]error, error) {
+	invalid := make(map[string]error)
+
+	rows, err := conn.Query(ctx, sqlAdjustedPrices+`SELECT composite_figi, max(event_date) FROM quotes WHERE split_factor <= 0 OR (close > 0 AND dividend <= -close) GROUP BY composite_figi UNION ALL SELECT l.composite_figi, NULL::date FROM (SELECT DISTINCT ON (composite_figi) composite_figi, close FROM quotes ORDER BY composite_figi, event_date DESC) l JOIN delistings d ON d.composite_figi = l.composite_figi WHERE $2 AND d.delisting_return IS NULL AND (d.final_payment IS NULL OR l.close IS NULL OR l.close <= 0)`, figis, opts.Delisting == DelistingFold)
+	if err != nil {
+		log.Error().Err(err).Int("NumAssets", len(figis)).Msg("could not check adjustment factors in database")
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var compositeFigi string
+		var eventDate pgtype.Date
+		if err := rows.Scan(&compositeFigi, &eventDate); err != nil {
+			log.Error().Err(err).Msg("could not scan invalid adjustment factors")
+			return nil, err
+		}
+
+		switch {
+		case eventDate.Status == pgtype.Present:
+			log.Warn().Str("CompositeFigi", compositeFigi).Time("EventDate", eventDate.Time).Msg("asset has an invalid split factor or dividend and was not adjusted")
+			invalid[compositeFigi] = fmt.Errorf("%w: %s on %s", ErrInvalidAdjustmentFactor, compositeFigi, eventDate.Time.Format("2006-01-02"))
+		case invalid[compositeFigi] == nil:
+			// the Go engine fails on the factors before it reaches the
+			// delisting
+			log.Warn().Str("CompositeFigi", compositeFigi).Msg("asset has an invalid delisting and was not adjusted")
+			invalid[compositeFigi] = fmt.Errorf("%w: %s", ErrInvalidDelisting, compositeFigi)
+		}
+	}
+
+	return invalid, rows.Err()
+}
+
+// saveAdjCloseSQL updates the adjusted prices and adjustment factors of a
+// batch of assets in a single transaction
+func saveAdjCloseSQL(ctx context.Context, conn PgxIface, figis []string, opts *AdjustOptions) error {
+	fold := opts.Delisting == DelistingFold
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("could not begin db transaction to adjust eod prices")
+		return err
+	}
+
+	// the adjusted prices are calculated once into the staging table the Go
+	// engine uses and every later statement reads them from there
+	var numRows int
+	numRows, err = stageAdjCloseSQL(ctx, tx, figis, fold)
+
+	if err == nil {
+		err = quarantineSQL(ctx, tx, figis, fold)
+	}
+
+	if err == nil {
+		err = saveRunChanges(ctx, tx, "", nil, "eod_adj_close_staging", []Series{TotalReturnSeries}, opts.Revision)
+	}
+
+	if err == nil {
+		err = saveStagedAdjClose(ctx, tx, numRows, []Series{TotalReturnSeries})
+	}
+
+	if err == nil {
+		err = saveRunFactors(ctx, tx, figis, opts.Revision)
+	}
+
+	var reasons map[string]string
+	if err == nil {
+		reasons, err = factorChangesSQL(ctx, tx, figis)
+	}
+
+	if err == nil {
+		if _, err = tx.Exec(ctx, `DELETE FROM eod_adjustment_factors WHERE composite_figi = ANY($1)`, figis); err != nil {
+			log.Error().Err(err).Int("NumAssets", len(figis)).Msg("could not delete adjustment factors")
+		}
+	}
+
+	if err == nil {
+		if _, err = tx.Exec(ctx, `INSERT INTO eod_adjustment_factors (composite_figi, event_date, split_factor, dividend_factor) SELECT composite_figi, event_date, split_factor, dividend_factor FROM eod_adj_close_staging`); err != nil {
+			log.Error().Err(err).Int("NumAssets", len(figis)).Msg("could not insert adjustment factors")
+		}
+	}
+
+	if err == nil {
+		err = saveAdjCloseHistory(ctx, tx, figis, nil, opts.Revision, reasons)
+	}
+
+	if err != nil {
+		if err2 := tx.Rollback(ctx); err2 != nil {
+			log.Error().Err(err2).Msg("failed to rollback db transaction")
+		}
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		log.Error().Err(err).Msg("could not commit eod price update to database")
+		return err
+	}
+
+	return nil
+}
+
+// stageAdjCloseSQL calculates the adjusted prices of a batch of assets into
+// eod_adj_close_staging and returns the number of rows staged
+func stageAdjCloseSQL(ctx context.Context, tx pgx.Tx, figis []string, fold bool) (int, error) {
+	if _, err := createAdjCloseStaging(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(ctx, sqlAdjustedPrices+`INSERT INTO eod_adj_close_staging (composite_figi, event_date, adj_close, adj_open, adj_high, adj_low, adj_volume, split_factor, dividend_factor) SELECT composite_figi, event_date, adj_close, adj_open, adj_high, adj_low, adj_volume, split_factor, dividend_factor FROM adjusted`, figis, fold)
+	if err != nil {
+		log.Error().Err(err).Int("NumAssets", len(figis)).Msg("could not calculate adjusted prices in database")
+		return 0, err
+	}
+
+	return int(result.RowsAffected()), nil
+}
+
+// quarantineSQL records the quotes of a batch of assets with a zero, negative
+// or missing close in eod_quarantine under the carry policy, like
+// SaveQuarantineToDb does for the Go engine
+func quarantineSQL(ctx context.Context, tx pgx.Tx, figis []string, fold bool) error {
+	if _, err := tx.Exec(ctx, sqlAdjustedPrices+`INSERT INTO eod_quarantine (composite_figi, event_date, ticker, close, reason, policy) SELECT composite_figi, event_date, ticker, close, CASE WHEN close IS NULL THEN $3 WHEN close = 0 THEN $4 ELSE $5 END, $6 FROM quotes WHERE close IS NULL OR close <= 0 ON CONFLICT (composite_figi, event_date, reason) DO UPDATE SET ticker = EXCLUDED.ticker, close = EXCLUDED.close, policy = EXCLUDED.policy, detected_at = now()`,
+		figis, fold, QuarantineMissingClose, QuarantineZeroClose, QuarantineNegativeClose, string(CarryFactor)); err != nil {
+		log.Error().Err(err).Int("NumAssets", len(figis)).Msg("could not save quarantined eod quotes")
+		return err
+	}
+	return nil
+}
+
+// sqlFactorChanges lists the ex-dates of a batch of assets whose daily
+// adjustment factors in eod_adj_close_staging differ from the ones stored in
+// eod_adjustment_factors. It must run before the stored factors are replaced.
+const sqlFactorChanges = `WITH new_daily AS (
+	SELECT composite_figi, event_date, lead(split_factor) OVER w / split_factor AS split, lead(dividend_factor) OVER w / dividend_factor AS dividend
+	FROM eod_adj_close_staging
+	WINDOW w AS (PARTITION BY composite_figi ORDER BY event_date DESC)
+), old_daily AS (
+	SELECT composite_figi, event_date, lead(split_factor) OVER w / NULLIF(split_factor, 0) AS split, lead(dividend_factor) OVER w / NULLIF(dividend_factor, 0) AS dividend
+	FROM eod_adjustment_factors WHERE composite_figi = ANY($1)
+	WINDOW w AS (PARTITION BY composite_figi ORDER BY event_date DESC)
+)
+SELECT n.composite_figi, n.event_date, COALESCE(o.split, 1), COALESCE(o.dividend, 1), n.split, n.dividend
+FROM new_daily n LEFT JOIN old_daily o ON o.composite_figi = n.composite_figi AND o.event_date = n.event_date
+WHERE n.split IS NOT NULL AND EXISTS (SELECT 1 FROM eod_adjustment_factors s WHERE s.composite_figi = n.composite_figi)
+	AND (abs(n.split - COALESCE(o.split, 1)) > $2 * abs(COALESCE(o.split, 1)) OR abs(n.dividend - COALESCE(o.dividend, 1)) > $2 * abs(COALESCE(o.dividend, 1)))
+ORDER BY n.composite_figi, n.event_date DESC`
+
+// factorChangesSQL returns the corporate actions that changed for each asset
+// in a batch, derived by comparing the stored adjustment factors with the
+// staged ones the sql engine is about to write
+func factorChangesSQL(ctx context.Context, tx pgx.Tx, figis []string) (map[string]string, error) {
+	reasons := make(map[string]string, len(figis))
+	for _, compositeFigi := range figis {
+		reasons[compositeFigi] = "initial adjustment"
+	}
+
+	rows, err := tx.Query(ctx, `SELECT DISTINCT composite_figi FROM eod_adjustment_factors WHERE composite_figi = ANY($1)`, figis)
+	if err != nil {
+		log.Error().Err(err).Int("NumAssets", len(figis)).Msg("could not load stored adjustment factors")
+		return nil, err
+	}
+	for rows.Next() {
+		var compositeFigi string
+		if err := rows.Scan(&compositeFigi); err != nil {
+			rows.Close()
+			log.Error().Err(err).Msg("could not scan stored adjustment factors")
+			return nil, err
+		}
+		reasons[compositeFigi] = ""
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Int("NumAssets", len(figis)).Msg("could not read stored adjustment factors")
+		return nil, err
+	}
+
+	changes := make(map[string][]factorChange)
+	rows, err = tx.Query(ctx, sqlFactorChanges, figis, factorTolerance)
+	if err != nil {
+		log.Error().Err(err).Int("NumAssets", len(figis)).Msg("could not compare adjustment factors")
+		return nil, err
+	}
+	for rows.Next() {
+		var compositeFigi string
+		change := factorChange{}
+		if err := rows.Scan(&compositeFigi, &change.exDate, &change.oldSplit, &change.oldDividend, &change.newSplit, &change.newDividend); err != nil {
+			rows.Close()
+			log.Error().Err(err).Msg("could not scan changed adjustment factors")
+			return nil, err
+		}
+		changes[compositeFigi] = append(changes[compositeFigi], change)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Int("NumAssets", len(figis)).Msg("could not read changed adjustment factors")
+		return nil, err
+	}
+
+	for compositeFigi, assetChanges := range changes {
+		reasons[compositeFigi] = describeFactorChanges(assetChanges)
+	}
+
+	return reasons, nil
+}
+
+// diffAdjCloseSQL compares the total return series the sql engine would write
+// for a batch of assets with the stored values
+func diffAdjCloseSQL(ctx context.Context, conn PgxIface, figis []string, opts *AdjustOptions) ([]*AdjCloseDiff, error) {
+	prices := make(map[string][]*Eod, len(figis))
+
+	rows, err := conn.Query(ctx, sqlAdjustedPrices+`SELECT composite_figi, event_date, adj_close, adj_open, adj_high, adj_low, adj_volume FROM adjusted ORDER BY composite_figi, event_date DESC`, figis, opts.Delisting == DelistingFold)
+	if err != nil {
+		log.Error().Err(err).Int("NumAssets", len(figis)).Msg("could not calculate adjusted prices in database")
+		return nil, err
+	}
+
+	for rows.Next() {
+		myEod := &Eod{}
+		var adjClose pgtype.Float8
+		if err := rows.Scan(&myEod.CompositeFigi, &myEod.EventDate, &adjClose, &myEod.AdjOpen, &myEod.AdjHigh, &myEod.AdjLow, &myEod.AdjVolume); err != nil {
+			rows.Close()
+			log.Error().Err(err).Msg("could not scan adjusted price calculated in database")
+			return nil, err
+		}
+		myEod.AdjClose = adjClose.Float
+		myEod.MissingClose = adjClose.Status != pgtype.Present
+		prices[myEod.CompositeFigi] = append(prices[myEod.CompositeFigi], myEod)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Int("NumAssets", len(figis)).Msg("could not read adjusted prices calculated in database")
+		return nil, err
+	}
+
+	diffs := make([]*AdjCloseDiff, 0, len(figis))
+	for _, compositeFigi := range figis {
+		diff, err := DiffAssetAdjClose(ctx, conn, compositeFigi, prices[compositeFigi], opts.Tolerance)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, diff)
+	}
+
+	return diffs, nil
+}
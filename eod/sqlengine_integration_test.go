@@ -0,0 +1,243 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+// These specs run the sql engine against a real PostgreSQL and are skipped
+// unless EOD_TEST_DATABASE_URL is set. Each run creates its own schema with
+// minimal eod and assets tables and every migration, and drops it afterwards.
+var _ = Describe("sql engine on PostgreSQL", Ordered, func() {
+	var (
+		ctx    context.Context
+		conn   *pgx.Conn
+		schema string
+	)
+
+	day := func(n int) time.Time {
+		return time.Date(2021, 1, n, 0, 0, 0, 0, time.UTC)
+	}
+
+	BeforeAll(func() {
+		url := os.Getenv("EOD_TEST_DATABASE_URL")
+		if url == "" {
+			Skip("EOD_TEST_DATABASE_URL is not set")
+		}
+
+		ctx = context.Background()
+		schema = fmt.Sprintf("eod_maintenance_test_%d", time.Now().UnixNano())
+
+		config, err := pgx.ParseConfig(url)
+		Expect(err).To(BeNil())
+		config.RuntimeParams["search_path"] = schema
+		conn, err = pgx.ConnectConfig(ctx, config)
+		Expect(err).To(BeNil())
+
+		_, err = conn.Exec(ctx, fmt.Sprintf(`CREATE SCHEMA %s;
+CREATE TABLE eod (
+    event_date      DATE NOT NULL,
+    ticker          TEXT NOT NULL,
+    composite_figi  TEXT NOT NULL,
+    open            DOUBLE PRECISION,
+    high            DOUBLE PRECISION,
+    low             DOUBLE PRECISION,
+    close           DOUBLE PRECISION,
+    volume          BIGINT,
+    dividend        DOUBLE PRECISION NOT NULL DEFAULT 0,
+    split_factor    DOUBLE PRECISION NOT NULL DEFAULT 1,
+    adj_close       DOUBLE PRECISION,
+    CONSTRAINT eod_pkey PRIMARY KEY (ticker, event_date)
+);
+CREATE TABLE assets (
+    ticker          TEXT NOT NULL,
+    composite_figi  TEXT NOT NULL,
+    listed_utc      TIMESTAMPTZ NOT NULL
+);`, schema))
+		Expect(err).To(BeNil())
+
+		migrations, err := filepath.Glob("../migrations/*.up.sql")
+		Expect(err).To(BeNil())
+		Expect(migrations).ToNot(BeEmpty())
+		sort.Strings(migrations)
+		for _, migration := range migrations {
+			sql, err := os.ReadFile(migration)
+			Expect(err).To(BeNil())
+			_, err = conn.Exec(ctx, string(sql))
+			Expect(err).To(BeNil(), migration)
+		}
+
+		quote := func(eventDate time.Time, ticker, figi string, close interface{}, dividend, splitFactor float64, ohlv ...interface{}) {
+			if len(ohlv) == 0 {
+				ohlv = []interface{}{nil, nil, nil, nil}
+			}
+			_, err := conn.Exec(ctx, `INSERT INTO eod (event_date, ticker, composite_figi, close, dividend, split_factor, open, high, low, volume) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+				eventDate, ticker, figi, close, dividend, splitFactor, ohlv[0], ohlv[1], ohlv[2], ohlv[3])
+			Expect(err).To(BeNil())
+		}
+
+		// splits, dividends, a zero close with a dividend and a missing close
+		quote(day(1), "AAA", "AAA", 40.0, 0, 1, 39.0, 41.0, 38.0, 1000)
+		quote(day(2), "AAA", "AAA", 20.0, 0, 2, 19.5, 20.5, 19.0, 2000)
+		quote(day(3), "AAA", "AAA", 21.0, 0.5, 1, 20.0, 21.5, 19.5, 2100)
+		quote(day(4), "AAA", "AAA", 0.0, 0.3, 1)
+		quote(day(5), "AAA", "AAA", nil, 0, 1)
+		quote(day(6), "AAA", "AAA", 22.0, 0.2, 1, 21.0, 22.5, 20.5, 1900)
+		quote(day(7), "AAA", "AAA", 11.0, 0, 2, 10.5, 11.5, 10.0, 4000)
+
+		// ZNEW was listed on day 3 while AOLD still quoted that day with the
+		// dividend
+		_, err = conn.Exec(ctx, `INSERT INTO assets (ticker, composite_figi, listed_utc) VALUES ('AOLD', 'BBB', $1), ('ZNEW', 'BBB', $2)`, day(1), day(3))
+		Expect(err).To(BeNil())
+		quote(day(1), "AOLD", "BBB", 20.0, 0, 1)
+		quote(day(2), "AOLD", "BBB", 20.0, 0, 1)
+		quote(day(3), "AOLD", "BBB", 11.0, 1.0, 2)
+		quote(day(3), "ZNEW", "BBB", 10.0, 0, 2)
+		quote(day(4), "ZNEW", "BBB", 10.0, 0, 1)
+
+		// delisted for a final payment
+		quote(day(1), "CCC", "CCC", 10.0, 0, 1)
+		quote(day(2), "CCC", "CCC", 10.5, 0.1, 1)
+		quote(day(3), "CCC", "CCC", 9.0, 0, 1)
+		_, err = conn.Exec(ctx, `INSERT INTO delistings (composite_figi, delisting_date, final_payment) VALUES ('CCC', $1, 8.0)`, day(3))
+		Expect(err).To(BeNil())
+
+		// a zero split factor fails the asset in both engines
+		quote(day(1), "BAD", "BAD", 10.0, 0, 1)
+		quote(day(2), "BAD", "BAD", 10.0, 0, 0)
+
+		// a final payment cannot be turned into a return without a final
+		// close, which fails the asset in both engines
+		quote(day(1), "DDD", "DDD", 10.0, 0, 1)
+		quote(day(2), "DDD", "DDD", nil, 0, 1)
+		_, err = conn.Exec(ctx, `INSERT INTO delistings (composite_figi, delisting_date, final_payment) VALUES ('DDD', $1, 8.0)`, day(2))
+		Expect(err).To(BeNil())
+	})
+
+	AfterAll(func() {
+		if conn != nil {
+			_, err := conn.Exec(ctx, fmt.Sprintf("DROP SCHEMA %s CASCADE", schema))
+			Expect(err).To(BeNil())
+			Expect(conn.Close(ctx)).To(Succeed())
+		}
+	})
+
+	float8 := func(val interface{}) pgtype.Float8 {
+		switch v := val.(type) {
+		case pgtype.Float8:
+			return v
+		case float64:
+			return pgtype.Float8{Float: v, Status: pgtype.Present}
+		}
+		Fail(fmt.Sprintf("unexpected series value %#v", val))
+		return pgtype.Float8{}
+	}
+
+	expectClose := func(stored pgtype.Float8, expected pgtype.Float8, description string) {
+		Expect(stored.Status).To(Equal(expected.Status), description)
+		if expected.Status == pgtype.Present {
+			Expect(stored.Float).To(BeNumerically("~", expected.Float, 1e-9*math.Max(1, math.Abs(expected.Float))), description)
+		}
+	}
+
+	It("should match the go engine", func() {
+		figis := []string{"AAA", "BBB", "BAD", "CCC", "DDD"}
+		opts := &eod.AdjustOptions{Delisting: eod.DelistingFold, DryRun: true}
+
+		expected := make(map[string][]*eod.Eod)
+		for _, figi := range figis {
+			prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, conn, figi, opts)
+			if figi == "BAD" {
+				Expect(err).To(MatchError(eod.ErrInvalidAdjustmentFactor))
+				continue
+			}
+			if figi == "DDD" {
+				Expect(err).To(MatchError(eod.ErrInvalidDelisting))
+				continue
+			}
+			Expect(err).To(BeNil(), figi)
+			expected[figi] = prices
+		}
+
+		summary := eod.AdjustAssets(ctx, conn, figis, 1, &eod.AdjustOptions{Engine: eod.SQLEngine, Delisting: eod.DelistingFold, BatchSize: len(figis)})
+		Expect(summary.Succeeded).To(Equal([]string{"AAA", "BBB", "CCC"}))
+		Expect(summary.Failed).To(HaveLen(2))
+		Expect(summary.Failed["BAD"]).To(MatchError(eod.ErrInvalidAdjustmentFactor))
+		Expect(summary.Failed["DDD"]).To(MatchError(eod.ErrInvalidDelisting))
+
+		for figi, prices := range expected {
+			for _, price := range prices {
+				description := fmt.Sprintf("%s on %s", figi, price.EventDate.Format("2006-01-02"))
+
+				rows, err := conn.Query(ctx, `SELECT adj_close, adj_open, adj_high, adj_low, adj_volume FROM eod WHERE composite_figi = $1 AND event_date = $2`, figi, price.EventDate)
+				Expect(err).To(BeNil())
+				numRows := 0
+				for rows.Next() {
+					stored := make([]pgtype.Float8, 5)
+					Expect(rows.Scan(&stored[0], &stored[1], &stored[2], &stored[3], &stored[4])).To(Succeed())
+					for idx, val := range eod.TotalReturnSeries.Values(price) {
+						expectClose(stored[idx], float8(val), fmt.Sprintf("%s %s", eod.TotalReturnSeries.Columns()[idx], description))
+					}
+					numRows++
+				}
+				rows.Close()
+				Expect(rows.Err()).To(BeNil())
+				Expect(numRows).To(BeNumerically(">", 0), description)
+
+				var splitFactor, dividendFactor float64
+				Expect(conn.QueryRow(ctx, `SELECT split_factor, dividend_factor FROM eod_adjustment_factors WHERE composite_figi = $1 AND event_date = $2`, figi, price.EventDate).
+					Scan(&splitFactor, &dividendFactor)).To(Succeed(), description)
+				Expect(splitFactor).To(BeNumerically("~", price.CumSplitFactor, 1e-9), description)
+				Expect(dividendFactor).To(BeNumerically("~", price.CumDividendFactor, 1e-9), description)
+			}
+		}
+	})
+
+	It("should quarantine zero and missing closes like the go engine", func() {
+		rows, err := conn.Query(ctx, `SELECT event_date, reason, policy FROM eod_quarantine WHERE composite_figi = 'AAA' ORDER BY event_date`)
+		Expect(err).To(BeNil())
+		defer rows.Close()
+
+		reasons := make(map[time.Time]string)
+		for rows.Next() {
+			var eventDate time.Time
+			var reason, policy string
+			Expect(rows.Scan(&eventDate, &reason, &policy)).To(Succeed())
+			Expect(policy).To(Equal(string(eod.CarryFactor)))
+			reasons[eventDate] = reason
+		}
+		Expect(rows.Err()).To(BeNil())
+		Expect(reasons).To(Equal(map[time.Time]string{day(4): eod.QuarantineZeroClose, day(5): eod.QuarantineMissingClose}))
+	})
+
+	It("should leave the assets that failed unchanged", func() {
+		var numAdjusted int
+		Expect(conn.QueryRow(ctx, `SELECT count(*) FROM eod WHERE composite_figi IN ('BAD', 'DDD') AND adj_close IS NOT NULL`).Scan(&numAdjusted)).To(Succeed())
+		Expect(numAdjusted).To(Equal(0))
+	})
+})
@@ -0,0 +1,240 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/jackc/pgtype"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("sql engine", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+		day1 time.Time
+		day2 time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+
+		day1 = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		day2 = time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	It("should parse engine names", func() {
+		engine, err := eod.ParseEngine("sql")
+		Expect(err).To(BeNil())
+		Expect(engine).To(Equal(eod.SQLEngine))
+
+		_, err = eod.ParseEngine("spark")
+		Expect(err).To(MatchError(eod.ErrUnknownEngine))
+	})
+
+	It("should only accept options the engine implements", func() {
+		Expect(eod.CheckSQLEngine(&eod.AdjustOptions{})).To(Succeed())
+		Expect(eod.CheckSQLEngine(&eod.AdjustOptions{Adjuster: &eod.CRSPAdjuster{}, Delisting: eod.DelistingFold, Series: []eod.Series{eod.TotalReturnSeries}})).To(Succeed())
+
+		Expect(eod.CheckSQLEngine(&eod.AdjustOptions{Adjuster: &eod.AdditiveAdjuster{}})).To(MatchError(eod.ErrUnsupportedBySQL))
+		Expect(eod.CheckSQLEngine(&eod.AdjustOptions{ActionSource: eod.TableActions})).To(MatchError(eod.ErrUnsupportedBySQL))
+		Expect(eod.CheckSQLEngine(&eod.AdjustOptions{Delisting: eod.DelistingRow})).To(MatchError(eod.ErrUnsupportedBySQL))
+		Expect(eod.CheckSQLEngine(&eod.AdjustOptions{Series: []eod.Series{eod.SplitSeries}})).To(MatchError(eod.ErrUnsupportedBySQL))
+		Expect(eod.CheckSQLEngine(&eod.AdjustOptions{ZeroPricePolicy: eod.SkipRow})).To(MatchError(eod.ErrUnsupportedBySQL))
+	})
+
+	// expectFactorCheck expects the check for invalid assets; invalid
+	// delistings are listed with a NULL date
+	expectFactorCheck := func(batch []string, fold bool, invalid ...string) {
+		rows := mock.NewRows([]string{"composite_figi", "event_date"})
+		for _, figi := range invalid {
+			if figi == "DELISTED" {
+				rows.AddRow(figi, pgtype.Date{Status: pgtype.Null})
+			} else {
+				rows.AddRow(figi, pgtype.Date{Time: day2, Status: pgtype.Present})
+			}
+		}
+		mock.ExpectQuery("^WITH quotes AS (.+) SELECT composite_figi, max\\(event_date\\) FROM quotes WHERE split_factor <= 0 OR \\(close > 0 AND dividend <= -close\\) GROUP BY composite_figi UNION ALL SELECT (.+) JOIN delistings d (.+) WHERE \\$2 AND d.delisting_return IS NULL (.+)$").
+			WithArgs(batch, fold).
+			WillReturnRows(rows)
+	}
+
+	// expectSave expects the statements saving a batch; the first asset has
+	// stored factors and a new dividend, the others are adjusted for the
+	// first time
+	expectSave := func(batch []string, fold bool) {
+		mock.ExpectBegin()
+		mock.ExpectExec("^CREATE TEMPORARY TABLE eod_adj_close_staging").WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+		mock.ExpectExec("^WITH quotes AS (.+) INSERT INTO eod_adj_close_staging (.+) FROM adjusted$").
+			WithArgs(batch, fold).
+			WillReturnResult(pgxmock.NewResult("INSERT", 10))
+		mock.ExpectExec("^WITH quotes AS (.+) INSERT INTO eod_quarantine (.+) FROM quotes WHERE close IS NULL OR close <= 0 ON CONFLICT").
+			WithArgs(batch, fold, eod.QuarantineMissingClose, eod.QuarantineZeroClose, eod.QuarantineNegativeClose, "carry").
+			WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		mock.ExpectExec("^UPDATE eod SET adj_close = s.adj_close, (.+) FROM eod_adj_close_staging s").
+			WillReturnResult(pgxmock.NewResult("UPDATE", 10))
+		mock.ExpectQuery("^SELECT DISTINCT composite_figi FROM eod_adjustment_factors WHERE composite_figi = ANY").
+			WithArgs(batch).
+			WillReturnRows(mock.NewRows([]string{"composite_figi"}).AddRow(batch[0]))
+		mock.ExpectQuery("^WITH new_daily AS (.+) FROM eod_adj_close_staging (.+), old_daily AS (.+) SELECT (.+) FROM new_daily n LEFT JOIN old_daily o (.+)").
+			WithArgs(batch, pgxmock.AnyArg()).
+			WillReturnRows(mock.NewRows([]string{"composite_figi", "event_date", "old_split", "old_dividend", "new_split", "new_dividend"}).
+				AddRow(batch[0], time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC), 1.0, 1.0, 1.0, 1.01))
+		mock.ExpectExec("^DELETE FROM eod_adjustment_factors WHERE composite_figi = ANY").
+			WithArgs(batch).
+			WillReturnResult(pgxmock.NewResult("DELETE", 10))
+		mock.ExpectExec("^INSERT INTO eod_adjustment_factors (.+) FROM eod_adj_close_staging$").
+			WillReturnResult(pgxmock.NewResult("INSERT", 10))
+		for _, figi := range batch {
+			reason := pgtype.Text{String: "initial adjustment", Status: pgtype.Present}
+			if figi == batch[0] {
+				reason = pgtype.Text{String: "new dividend on 2021-01-05", Status: pgtype.Present}
+			}
+			mock.ExpectExec("^INSERT INTO eod_adj_close_history").WithArgs(figi, pgxmock.AnyArg(), reason).WillReturnResult(pgxmock.NewResult("INSERT", 5))
+		}
+		mock.ExpectCommit()
+	}
+
+	It("should update each batch of assets in one transaction", func() {
+		for _, batch := range [][]string{{"AAA", "BBB"}, {"CCC"}} {
+			expectFactorCheck(batch, true)
+			expectSave(batch, true)
+		}
+
+		summary := eod.AdjustAssets(ctx, mock, []string{"AAA", "BBB", "CCC"}, 4, &eod.AdjustOptions{
+			Engine:    eod.SQLEngine,
+			BatchSize: 2,
+			Delisting: eod.DelistingFold,
+		})
+		Expect(summary.Failed).To(BeEmpty())
+		Expect(summary.Succeeded).To(Equal([]string{"AAA", "BBB", "CCC"}))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should fail every asset in a batch that fails", func() {
+		expectFactorCheck([]string{"AAA", "BBB"}, false)
+		mock.ExpectBegin()
+		mock.ExpectExec("^CREATE TEMPORARY TABLE eod_adj_close_staging").WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+		mock.ExpectExec("^WITH quotes AS (.+) INSERT INTO eod_adj_close_staging").
+			WithArgs([]string{"AAA", "BBB"}, false).
+			WillReturnError(errors.New("division by zero"))
+		mock.ExpectRollback()
+
+		summary := eod.AdjustAssets(ctx, mock, []string{"AAA", "BBB"}, 1, &eod.AdjustOptions{Engine: eod.SQLEngine})
+		Expect(summary.Succeeded).To(BeEmpty())
+		Expect(summary.Failed).To(HaveLen(2))
+		Expect(summary.Failed["AAA"]).To(MatchError(eod.ErrSQLEngineBatchFailed))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should fail only the assets with a split factor or dividend the go engine rejects", func() {
+		expectFactorCheck([]string{"AAA", "BAD"}, false, "BAD")
+		expectSave([]string{"AAA"}, false)
+
+		summary := eod.AdjustAssets(ctx, mock, []string{"AAA", "BAD"}, 1, &eod.AdjustOptions{Engine: eod.SQLEngine})
+		Expect(summary.Succeeded).To(Equal([]string{"AAA"}))
+		Expect(summary.Failed).To(HaveLen(1))
+		Expect(summary.Failed["BAD"]).To(MatchError(eod.ErrInvalidAdjustmentFactor))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should fail assets whose delisting cannot be folded in like the go engine", func() {
+		expectFactorCheck([]string{"AAA", "DELISTED"}, true, "DELISTED")
+		expectSave([]string{"AAA"}, true)
+
+		summary := eod.AdjustAssets(ctx, mock, []string{"AAA", "DELISTED"}, 1, &eod.AdjustOptions{Engine: eod.SQLEngine, Delisting: eod.DelistingFold})
+		Expect(summary.Succeeded).To(Equal([]string{"AAA"}))
+		Expect(summary.Failed).To(HaveLen(1))
+		Expect(summary.Failed["DELISTED"]).To(MatchError(eod.ErrInvalidDelisting))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should compare prices calculated in the database with stored values on a dry run", func() {
+		expectFactorCheck([]string{"AAA"}, false)
+		mock.ExpectQuery("^WITH quotes AS (.+) SELECT composite_figi, event_date, adj_close, (.+) FROM adjusted").
+			WithArgs([]string{"AAA"}, false).
+			WillReturnRows(mock.NewRows([]string{"composite_figi", "event_date", "adj_close", "adj_open", "adj_high", "adj_low", "adj_volume"}).
+				AddRow("AAA", day2, 10.0, nil, nil, nil, nil).
+				AddRow("AAA", day1, 9.5, nil, nil, nil, nil))
+		mock.ExpectQuery("^SELECT event_date, (.+) FROM eod WHERE composite_figi = (.+)").
+			WithArgs("AAA").
+			WillReturnRows(mock.NewRows([]string{"event_date", "adj_close", "adj_open", "adj_high", "adj_low", "adj_volume"}).
+				AddRow(day2, 10.0, nil, nil, nil, nil).
+				AddRow(day1, 9.0, nil, nil, nil, nil))
+
+		summary := eod.AdjustAssets(ctx, mock, []string{"AAA"}, 1, &eod.AdjustOptions{Engine: eod.SQLEngine, DryRun: true})
+		Expect(summary.Failed).To(BeEmpty())
+		Expect(summary.Diffs).To(HaveLen(1))
+		Expect(summary.Diffs[0].RowsChanged).To(Equal(1))
+		Expect(summary.Diffs[0].FirstChanged).To(Equal(day1))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should match the go engine within tolerance using a reverse cumulative sum of logs", func() {
+		numRows := 25000
+		start := time.Date(1925, 1, 1, 0, 0, 0, 0, time.UTC)
+		closes := make([]float64, numRows)
+		dividends := make([]float64, numRows)
+		splits := make([]float64, numRows)
+		for idx := range closes {
+			closes[idx] = 100 + float64(idx%37)*0.37
+			dividends[idx] = 0.013 + float64(idx%11)*0.001
+			splits[idx] = 1.0
+			if idx%5000 == 4999 {
+				splits[idx] = 2.0
+			}
+		}
+		closes[100] = 0
+
+		rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"})
+		for idx := numRows - 1; idx >= 0; idx-- {
+			rows.AddRow(start.AddDate(0, 0, idx), "TEST", "TEST", closes[idx], dividends[idx], splits[idx], nil, nil, nil, nil)
+		}
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WithArgs("TEST").WillReturnRows(rows)
+
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{DryRun: true})
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(numRows))
+
+		// the same calculation as sqlAdjustedPrices: factors are exp of the
+		// sum of logs over later quotes
+		logSplit := 0.0
+		logDividend := 0.0
+		for idx := numRows - 1; idx >= 0; idx-- {
+			adjClose := closes[idx] / (math.Exp(logSplit) * math.Exp(logDividend))
+			Expect(prices[numRows-1-idx].AdjClose).To(BeNumerically("~", adjClose, 1e-9))
+
+			logSplit += math.Log(splits[idx])
+			if closes[idx] > 0 {
+				logDividend += math.Log(1 + dividends[idx]/closes[idx])
+			}
+		}
+	})
+})
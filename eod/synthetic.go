@@ -63,6 +63,12 @@ func UpdateSyntheticHistory(ctx context.Context, conn *pgx.Conn, asset *Syntheti
 
 // BuildSyntheticHistory iterates over all the components of a synthetic asset and calculates
 func BuildSyntheticHistory(ctx context.Context, asset *SyntheticAsset, history []*Eod) ([]*Eod, error) {
+	return BuildSyntheticHistoryWithArithmetic(ctx, asset, history, FloatArithmetic)
+}
+
+// BuildSyntheticHistoryWithArithmetic is BuildSyntheticHistory with the
+// running close compounded using arithmetic
+func BuildSyntheticHistoryWithArithmetic(ctx context.Context, asset *SyntheticAsset, history []*Eod, arithmetic Arithmetic) ([]*Eod, error) {
 	newHistory := make([]*Eod, 0)
 
 	// set starting value of synthetic asset
@@ -80,6 +86,10 @@ func BuildSyntheticHistory(ctx context.Context, asset *SyntheticAsset, history [
 		newHistory = append(newHistory, quote)
 	}
 
+	// the close is a running product of percent changes
+	closeFactor := newCumulativeFactor(arithmetic)
+	closeFactor.scale(quote.Close)
+
 	// read components, calculate percent change, add eod quotes
 	for _, component := range asset.Components {
 		if !component.End.Equal(time.Time{}) && component.End.Before(quote.EventDate) {
@@ -98,7 +108,8 @@ func BuildSyntheticHistory(ctx context.Context, asset *SyntheticAsset, history [
 				log.Info().Time("Date", pct.Date).Time("PctDate", pct.Date).Str("Name", component.Name).Msg("Component ended")
 				break
 			}
-			closePrice := quote.Close * pct.Percent
+			closeFactor.scale(pct.Percent)
+			closePrice := closeFactor.value()
 			quote = &Eod{
 				EventDate:     pct.Date,
 				Ticker:        asset.Symbol,
@@ -0,0 +1,88 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TickerChange records the first date a composite figi traded under a new
+// ticker
+type TickerChange struct {
+	CompositeFigi string
+	EventDate     time.Time
+	OldTicker     string
+	NewTicker     string
+}
+
+// TickerChanges returns every ticker change in eod for the given composite
+// figi's, or for all assets when none are given. Where tickers overlap only
+// the quote of the ticker in effect counts, so the duplicate quotes are not
+// reported as changes back and forth.
+func TickerChanges(ctx context.Context, conn PgxIface, compositeFigis ...string) ([]*TickerChange, error) {
+	changes := make([]*TickerChange, 0)
+
+	sql := `SELECT composite_figi, event_date, prev_ticker, ticker FROM (
+		SELECT composite_figi, event_date, ticker, LAG(ticker) OVER (PARTITION BY composite_figi ORDER BY event_date, ticker) AS prev_ticker FROM (
+			SELECT DISTINCT ON (composite_figi, event_date) composite_figi, event_date, ticker FROM eod %s ORDER BY composite_figi, event_date, ` + tickerInEffect + `, ticker
+		) q
+	) t WHERE prev_ticker IS NOT NULL AND prev_ticker != ticker ORDER BY composite_figi, event_date`
+
+	var args []interface{}
+	if len(compositeFigis) > 0 {
+		sql = fmt.Sprintf(sql, "WHERE composite_figi = ANY($1)")
+		args = append(args, compositeFigis)
+	} else {
+		sql = fmt.Sprintf(sql, "")
+	}
+
+	rows, err := conn.Query(ctx, sql, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("could not query ticker changes")
+		return changes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		change := &TickerChange{}
+		if err := rows.Scan(&change.CompositeFigi, &change.EventDate, &change.OldTicker, &change.NewTicker); err != nil {
+			log.Error().Err(err).Msg("could not scan ticker change")
+			return changes, err
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Msg("could not read ticker changes")
+		return changes, err
+	}
+
+	return changes, nil
+}
+
+// PrintTickerChanges writes a table of ticker changes to w
+func PrintTickerChanges(w io.Writer, changes []*TickerChange) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CompositeFigi\tDate\tOldTicker\tNewTicker")
+	for _, change := range changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", change.CompositeFigi, change.EventDate.Format("2006-01-02"), change.OldTicker, change.NewTicker)
+	}
+	tw.Flush()
+}
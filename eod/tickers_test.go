@@ -0,0 +1,103 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("assets that changed ticker", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	It("should adjust across the ticker change with a split on each side", func() {
+		// OLD traded until 2021-01-03 and split 2:1 on 2021-01-02; NEW
+		// started 2021-01-04 and split 2:1 on 2021-01-05
+		rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+			AddRow(time.Date(2021, 1, 6, 0, 0, 0, 0, time.UTC), "NEW", "FIGI", 10.0, 0.0, 1.0, nil, nil, nil, nil).
+			AddRow(time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC), "NEW", "FIGI", 10.0, 0.0, 2.0, nil, nil, nil, nil).
+			AddRow(time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), "NEW", "FIGI", 20.0, 0.0, 1.0, nil, nil, nil, nil).
+			AddRow(time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC), "OLD", "FIGI", 20.0, 0.0, 1.0, nil, nil, nil, nil).
+			AddRow(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), "OLD", "FIGI", 20.0, 0.0, 2.0, nil, nil, nil, nil).
+			AddRow(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), "OLD", "FIGI", 40.0, 0.0, 1.0, nil, nil, nil, nil)
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").WithArgs("FIGI").WillReturnRows(rows)
+
+		prices, err := eod.AdjustAssetEodPrice(ctx, mock, "FIGI")
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(6))
+
+		for _, price := range prices {
+			Expect(price.AdjClose).To(Equal(10.0), "AdjClose on %s", price.EventDate)
+		}
+		Expect(prices[3].Ticker).To(Equal("OLD"))
+		Expect(prices[3].CumSplitFactor).To(Equal(2.0))
+		Expect(prices[5].CumSplitFactor).To(Equal(4.0))
+	})
+
+	It("should keep the quote of the ticker in effect when tickers overlap", func() {
+		// ZNEW was listed on 2021-01-02 while AOLD still quoted that day;
+		// the dividend was only recorded on the AOLD quote
+		rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+			AddRow(time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC), "ZNEW", "FIGI", 10.0, 0.0, 1.0, nil, nil, nil, nil).
+			AddRow(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), "ZNEW", "FIGI", 10.0, 0.0, 2.0, nil, nil, nil, nil).
+			AddRow(time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC), "AOLD", "FIGI", 11.0, 1.0, 2.0, nil, nil, nil, nil).
+			AddRow(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), "AOLD", "FIGI", 20.0, 0.0, 1.0, nil, nil, nil, nil)
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, ticker IS DISTINCT FROM \\(SELECT a.ticker FROM assets a (.+) ORDER BY a.listed_utc DESC LIMIT 1\\), ticker$").WithArgs("FIGI").WillReturnRows(rows)
+
+		prices, err := eod.AdjustAssetEodPrice(ctx, mock, "FIGI")
+		Expect(err).To(BeNil())
+		Expect(prices).To(HaveLen(3))
+		Expect(prices[1].Ticker).To(Equal("ZNEW"))
+		Expect(prices[1].Close).To(Equal(10.0))
+		Expect(prices[1].Dividend).To(Equal(1.0))
+		Expect(prices[1].AdjClose).To(Equal(10.0))
+		Expect(prices[2].CumSplitFactor).To(Equal(2.0))
+		Expect(prices[2].CumDividendFactor).To(BeNumerically("~", 1.1, 1e-9))
+		Expect(prices[2].AdjClose).To(BeNumerically("~", 20.0/2.2, 1e-9))
+	})
+
+	It("should report ticker switch dates", func() {
+		mock.ExpectQuery("^SELECT composite_figi, event_date, prev_ticker, ticker FROM (.+) LAG\\(ticker\\) OVER \\(PARTITION BY composite_figi ORDER BY event_date, ticker\\) (.+) SELECT DISTINCT ON \\(composite_figi, event_date\\) (.+) FROM eod WHERE composite_figi = ANY\\(\\$1\\) ORDER BY composite_figi, event_date, ticker IS DISTINCT FROM (.+), ticker").
+			WithArgs([]string{"FIGI"}).
+			WillReturnRows(mock.NewRows([]string{"composite_figi", "event_date", "prev_ticker", "ticker"}).
+				AddRow("FIGI", time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), "OLD", "NEW"))
+
+		changes, err := eod.TickerChanges(ctx, mock, "FIGI")
+		Expect(err).To(BeNil())
+		Expect(changes).To(HaveLen(1))
+		Expect(changes[0].OldTicker).To(Equal("OLD"))
+		Expect(changes[0].NewTicker).To(Equal("NEW"))
+		Expect(changes[0].EventDate).To(Equal(time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)))
+	})
+})
@@ -14,7 +14,11 @@
 // limitations under the License.
 package eod
 
-import "time"
+import (
+	"time"
+
+	"github.com/jackc/pgtype"
+)
 
 type Eod struct {
 	EventDate     time.Time
@@ -23,8 +27,119 @@ type Eod struct {
 	CompositeFigi string
 	Close         float64
 	AdjClose      float64 `csv:"adjClose"`
-	Dividend      float64
+	SplitAdjClose float64
 	SplitFactor   float64
+
+	// Dividend is the cash distribution included in the adjustment;
+	// Dividends breaks the distributions on EventDate down by type,
+	// including any that were excluded
+	Dividend  float64
+	Dividends map[DividendType]float64 `csv:"-"`
+
+	// Distribution is the value per share of the non-cash distributions
+	// (e.g. spin-offs) in Distributions and of the Rights going ex on
+	// EventDate; the distributed assets are linked by their composite figi
+	Distribution  float64
+	Distributions []*Distribution   `csv:"-"`
+	Rights        []*RightsOffering `csv:"-"`
+
+	// Open, High, Low and Volume may be NULL in eod; their adjusted values
+	// are NULL when the raw value is
+	Open      pgtype.Float8 `csv:"-"`
+	High      pgtype.Float8 `csv:"-"`
+	Low       pgtype.Float8 `csv:"-"`
+	Volume    pgtype.Float8 `csv:"-"`
+	AdjOpen   pgtype.Float8 `csv:"-"`
+	AdjHigh   pgtype.Float8 `csv:"-"`
+	AdjLow    pgtype.Float8 `csv:"-"`
+	AdjVolume pgtype.Float8 `csv:"-"`
+
+	// MissingClose is set when the close in eod is NULL; the adjusted closes
+	// of the quote are then saved and exported as NULL
+	MissingClose bool `csv:"-"`
+
+	// Synthetic quotes are generated during adjustment (e.g. a delisting
+	// row) and have no row in eod
+	Synthetic bool `csv:"-"`
+
+	// CumSplitFactor and CumDividendFactor are the CRSP cumulative factors
+	// of all corporate actions after EventDate; AdjClose is Close divided
+	// by their product and SplitAdjClose is Close divided by CumSplitFactor.
+	// CumSplitFactor is the price factor: splits, stock dividends, spin-offs
+	// and rights; CumDividendFactor covers cash dividends.
+	CumSplitFactor    float64
+	CumDividendFactor float64
+
+	// CumNetDividendFactor is CumDividendFactor with cash dividends reduced
+	// by the withholding tax rate; NetAdjClose is Close divided by its
+	// product with CumSplitFactor
+	CumNetDividendFactor float64
+	NetAdjClose          float64 `csv:"-"`
+}
+
+// TotalDistribution returns the cash and non-cash distributions per share
+// that go ex on EventDate
+func (myEod *Eod) TotalDistribution() float64 {
+	return myEod.Dividend + myEod.Distribution
+}
+
+// AdjustOptions configures how assets are adjusted and saved; the zero value
+// uses CRSP adjustments, carries factors through invalid prices and writes
+// only the total return series
+type AdjustOptions struct {
+	// Engine selects where prices are calculated; defaults to GoEngine.
+	// SQLEngine adjusts BatchSize assets per statement (DefaultSQLBatchSize
+	// when 0) and only supports the options accepted by CheckSQLEngine
+	Engine    Engine
+	BatchSize int
+
+	// Adjuster is the adjustment methodology; defaults to CRSPAdjuster
+	Adjuster Adjuster
+
+	// Series lists the adjusted price series written to the database
+	Series []Series
+
+	// ActionSource selects where dividends and splits are read from;
+	// defaults to EodActions
+	ActionSource ActionSource
+
+	// ExcludedDividends lists dividend types that are left out of the
+	// adjusted series; all types are included by default
+	ExcludedDividends []DividendType
+
+	// Delisting controls how delisting returns are added to the adjusted
+	// history; defaults to DelistingIgnore, as does the --delisting flag
+	Delisting DelistingMode
+
+	// ZeroPricePolicy controls how quotes with a zero, negative or missing
+	// close are handled; defaults to CarryFactor
+	ZeroPricePolicy ZeroPricePolicy
+
+	// Arithmetic selects how cumulative factors are multiplied; defaults to
+	// FloatArithmetic
+	Arithmetic Arithmetic
+
+	// Rounding is applied to adjusted prices before they are compared or
+	// written to the database; the zero value leaves them unrounded
+	Rounding RoundingPolicy
+
+	// AsOf, when set, calculates prices as they would have appeared on that
+	// date using only quotes and corporate actions at or before it
+	AsOf time.Time
+
+	// Revision is recorded with the changed adj_close values in
+	// eod_adj_close_history; may be nil
+	Revision *Revision
+
+	// DryRun compares the calculated series with the stored values instead
+	// of saving them; differences within Tolerance are ignored
+	DryRun    bool
+	Tolerance Tolerance
+
+	// Validate refuses to adjust assets that fail hard data quality checks
+	// using ValidationRules (DefaultValidationRules when nil)
+	Validate        bool
+	ValidationRules *ValidationRules
 }
 
 type SyntheticAsset struct {
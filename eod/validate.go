@@ -0,0 +1,237 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrValidationFailed = errors.New("asset failed data quality checks")
+)
+
+// Severity of a validation issue. Assets with hard issues are not adjusted
+// when validation is enabled; soft issues are only reported.
+type Severity string
+
+const (
+	SeverityHard Severity = "hard"
+	SeveritySoft Severity = "soft"
+)
+
+// Names of the data quality checks
+const (
+	CheckMissingClose     = "missing close"
+	CheckNonPositivePrice = "non-positive price"
+	CheckSplitFactor      = "implausible split factor"
+	CheckDividend         = "dividend exceeds close"
+	CheckDuplicateDate    = "duplicate date"
+	CheckUnexplainedMove  = "unexplained move"
+)
+
+// ValidationRules holds the thresholds used by ValidateHistory
+type ValidationRules struct {
+	// MaxSplitFactor is the largest plausible split factor; reverse splits
+	// smaller than 1/MaxSplitFactor are also flagged
+	MaxSplitFactor float64
+
+	// MaxDailyMove is the largest absolute day-over-day percent change (as
+	// a fraction) allowed without a corporate action
+	MaxDailyMove float64
+}
+
+// DefaultValidationRules returns the rules used when none are specified
+func DefaultValidationRules() *ValidationRules {
+	return &ValidationRules{
+		MaxSplitFactor: 50,
+		MaxDailyMove:   .5,
+	}
+}
+
+// ValidationIssue is a data quality problem found in an eod quote
+type ValidationIssue struct {
+	CompositeFigi string
+	Ticker        string
+	EventDate     time.Time
+	Check         string
+	Severity      Severity
+	Message       string
+}
+
+// ValidationRecord is a row of a validation report
+type ValidationRecord struct {
+	CompositeFigi string `csv:"composite_figi" json:"composite_figi"`
+	Ticker        string `csv:"ticker" json:"ticker"`
+	EventDate     string `csv:"event_date" json:"event_date"`
+	Check         string `csv:"check" json:"check"`
+	Severity      string `csv:"severity" json:"severity"`
+	Message       string `csv:"message" json:"message"`
+}
+
+// validationQuote is the raw eod data needed to validate an asset
+type validationQuote struct {
+	EventDate   time.Time
+	Ticker      string
+	Open        pgtype.Float8
+	High        pgtype.Float8
+	Low         pgtype.Float8
+	Close       pgtype.Float8
+	Dividend    float64
+	SplitFactor float64
+}
+
+// HasHardIssues returns true if any issue is SeverityHard
+func HasHardIssues(issues []*ValidationIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == SeverityHard {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateAsset runs the data quality checks against every eod quote of an
+// asset. rules may be nil to use DefaultValidationRules.
+func ValidateAsset(ctx context.Context, conn PgxIface, compositeFigi string, rules *ValidationRules) ([]*ValidationIssue, error) {
+	if rules == nil {
+		rules = DefaultValidationRules()
+	}
+
+	rows, err := conn.Query(ctx, "SELECT event_date, ticker, open, high, low, close, dividend, split_factor FROM eod WHERE composite_figi = $1 ORDER BY event_date, "+tickerInEffect+", ticker", compositeFigi)
+	if err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not query eod quotes to validate")
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotes := make([]*validationQuote, 0)
+	for rows.Next() {
+		quote := &validationQuote{}
+		if err := rows.Scan(&quote.EventDate, &quote.Ticker, &quote.Open, &quote.High, &quote.Low, &quote.Close, &quote.Dividend, &quote.SplitFactor); err != nil {
+			log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not scan eod quote to validate")
+			return nil, err
+		}
+		quotes = append(quotes, quote)
+	}
+	if err := rows.Err(); err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not read eod quotes to validate")
+		return nil, err
+	}
+
+	return validateQuotes(compositeFigi, quotes, rules), nil
+}
+
+// validateQuotes checks quotes sorted oldest first
+func validateQuotes(compositeFigi string, quotes []*validationQuote, rules *ValidationRules) []*ValidationIssue {
+	issues := make([]*ValidationIssue, 0)
+	addIssue := func(quote *validationQuote, check string, severity Severity, format string, args ...interface{}) {
+		issues = append(issues, &ValidationIssue{
+			CompositeFigi: compositeFigi,
+			Ticker:        quote.Ticker,
+			EventDate:     quote.EventDate,
+			Check:         check,
+			Severity:      severity,
+			Message:       fmt.Sprintf(format, args...),
+		})
+	}
+
+	var prev *validationQuote
+	for _, quote := range quotes {
+		if prev != nil && quote.EventDate.Equal(prev.EventDate) {
+			// overlapping tickers of the figi are expected around a ticker
+			// change and adjusting keeps the quote of the ticker in effect
+			severity := SeveritySoft
+			if quote.Ticker == prev.Ticker {
+				severity = SeverityHard
+			}
+			addIssue(quote, CheckDuplicateDate, severity, "also quoted as %s", prev.Ticker)
+			continue
+		}
+
+		if quote.Close.Status != pgtype.Present {
+			addIssue(quote, CheckMissingClose, SeverityHard, "close is NULL")
+		}
+
+		for _, price := range []struct {
+			name  string
+			value pgtype.Float8
+		}{{"open", quote.Open}, {"high", quote.High}, {"low", quote.Low}, {"close", quote.Close}} {
+			if price.value.Status == pgtype.Present && price.value.Float <= 0 {
+				addIssue(quote, CheckNonPositivePrice, SeverityHard, "%s is %g", price.name, price.value.Float)
+			}
+		}
+
+		if quote.SplitFactor <= 0 || quote.SplitFactor > rules.MaxSplitFactor || quote.SplitFactor < 1/rules.MaxSplitFactor {
+			addIssue(quote, CheckSplitFactor, SeverityHard, "split factor is %g", quote.SplitFactor)
+		}
+
+		if quote.Close.Status == pgtype.Present && quote.Close.Float > 0 && quote.Dividend > quote.Close.Float {
+			addIssue(quote, CheckDividend, SeverityHard, "dividend %g is larger than close %g", quote.Dividend, quote.Close.Float)
+		}
+
+		if prev != nil && prev.Close.Status == pgtype.Present && prev.Close.Float > 0 &&
+			quote.Close.Status == pgtype.Present && quote.Close.Float > 0 &&
+			quote.SplitFactor == 1 && quote.Dividend == 0 {
+			move := quote.Close.Float/prev.Close.Float - 1
+			if math.Abs(move) > rules.MaxDailyMove {
+				addIssue(quote, CheckUnexplainedMove, SeveritySoft, "close moved %.2f%% from %g to %g without a corporate action", move*100, prev.Close.Float, quote.Close.Float)
+			}
+		}
+
+		prev = quote
+	}
+
+	return issues
+}
+
+// ValidationRecords converts issues into report records
+func ValidationRecords(issues []*ValidationIssue) []*ValidationRecord {
+	records := make([]*ValidationRecord, len(issues))
+	for idx, issue := range issues {
+		records[idx] = &ValidationRecord{
+			CompositeFigi: issue.CompositeFigi,
+			Ticker:        issue.Ticker,
+			EventDate:     issue.EventDate.Format("2006-01-02"),
+			Check:         issue.Check,
+			Severity:      string(issue.Severity),
+			Message:       issue.Message,
+		}
+	}
+	return records
+}
+
+// WriteValidationReport writes issues to w as either json or csv
+func WriteValidationReport(w io.Writer, format string, issues []*ValidationIssue) error {
+	return writeReport(w, format, ValidationRecords(issues))
+}
+
+// PrintValidationIssues writes a table of issues to w
+func PrintValidationIssues(w io.Writer, issues []*ValidationIssue) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CompositeFigi\tTicker\tDate\tSeverity\tCheck\tMessage")
+	for _, issue := range issues {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", issue.CompositeFigi, issue.Ticker, issue.EventDate.Format("2006-01-02"), issue.Severity, issue.Check, issue.Message)
+	}
+	tw.Flush()
+}
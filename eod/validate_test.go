@@ -0,0 +1,167 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("data quality validation", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+		rows *pgxmock.Rows
+	)
+
+	day := func(d int) time.Time {
+		return time.Date(2021, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+
+		rows = mock.NewRows([]string{"event_date", "ticker", "open", "high", "low", "close", "dividend", "split_factor"})
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	expectQuery := func() {
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date, ticker IS DISTINCT FROM (.+), ticker$").WithArgs("TEST").WillReturnRows(rows)
+	}
+
+	checks := func(issues []*eod.ValidationIssue) []string {
+		names := make([]string, len(issues))
+		for idx, issue := range issues {
+			names[idx] = issue.Check
+		}
+		return names
+	}
+
+	It("should not report issues for clean data", func() {
+		rows.AddRow(day(1), "TEST", 9.5, 10.5, 9.0, 10.0, 0.0, 1.0).
+			AddRow(day(2), "TEST", 10.0, 11.5, 10.0, 11.0, 0.25, 1.0).
+			AddRow(day(3), "TEST", 5.5, 6.0, 5.0, 5.5, 0.0, 2.0)
+		expectQuery()
+
+		issues, err := eod.ValidateAsset(ctx, mock, "TEST", nil)
+		Expect(err).To(BeNil())
+		Expect(issues).To(BeEmpty())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should flag missing and non-positive prices", func() {
+		rows.AddRow(day(1), "TEST", 10.0, 10.0, 10.0, nil, 0.0, 1.0).
+			AddRow(day(2), "TEST", -1.0, 10.0, 10.0, 0.0, 0.0, 1.0)
+		expectQuery()
+
+		issues, err := eod.ValidateAsset(ctx, mock, "TEST", nil)
+		Expect(err).To(BeNil())
+		Expect(checks(issues)).To(Equal([]string{eod.CheckMissingClose, eod.CheckNonPositivePrice, eod.CheckNonPositivePrice}))
+		Expect(issues[1].Message).To(Equal("open is -1"))
+		Expect(issues[2].Message).To(Equal("close is 0"))
+		Expect(eod.HasHardIssues(issues)).To(BeTrue())
+	})
+
+	It("should flag implausible split factors", func() {
+		rows.AddRow(day(1), "TEST", nil, nil, nil, 10.0, 0.0, 0.0).
+			AddRow(day(2), "TEST", nil, nil, nil, 10.0, 0.0, 1000.0).
+			AddRow(day(3), "TEST", nil, nil, nil, 10.0, 0.0, 0.001)
+		expectQuery()
+
+		issues, err := eod.ValidateAsset(ctx, mock, "TEST", nil)
+		Expect(err).To(BeNil())
+		Expect(checks(issues)).To(Equal([]string{eod.CheckSplitFactor, eod.CheckSplitFactor, eod.CheckSplitFactor}))
+		Expect(issues[1].EventDate).To(Equal(day(2)))
+	})
+
+	It("should flag dividends larger than the close", func() {
+		rows.AddRow(day(1), "TEST", nil, nil, nil, 10.0, 12.0, 1.0)
+		expectQuery()
+
+		issues, err := eod.ValidateAsset(ctx, mock, "TEST", nil)
+		Expect(err).To(BeNil())
+		Expect(checks(issues)).To(Equal([]string{eod.CheckDividend}))
+		Expect(issues[0].Severity).To(Equal(eod.SeverityHard))
+	})
+
+	It("should warn about duplicate dates from overlapping tickers", func() {
+		rows.AddRow(day(1), "TEST", nil, nil, nil, 10.0, 0.0, 1.0).
+			AddRow(day(1), "TEST2", nil, nil, nil, 10.0, 0.0, 1.0)
+		expectQuery()
+
+		issues, err := eod.ValidateAsset(ctx, mock, "TEST", nil)
+		Expect(err).To(BeNil())
+		Expect(checks(issues)).To(Equal([]string{eod.CheckDuplicateDate}))
+		Expect(issues[0].Ticker).To(Equal("TEST2"))
+		Expect(issues[0].Severity).To(Equal(eod.SeveritySoft))
+		Expect(eod.HasHardIssues(issues)).To(BeFalse())
+	})
+
+	It("should fail duplicate dates of the same ticker", func() {
+		rows.AddRow(day(1), "TEST", nil, nil, nil, 10.0, 0.0, 1.0).
+			AddRow(day(1), "TEST", nil, nil, nil, 10.0, 0.0, 1.0)
+		expectQuery()
+
+		issues, err := eod.ValidateAsset(ctx, mock, "TEST", nil)
+		Expect(err).To(BeNil())
+		Expect(checks(issues)).To(Equal([]string{eod.CheckDuplicateDate}))
+		Expect(issues[0].Severity).To(Equal(eod.SeverityHard))
+	})
+
+	It("should flag large moves only when there is no corporate action", func() {
+		rows.AddRow(day(1), "TEST", nil, nil, nil, 10.0, 0.0, 1.0).
+			AddRow(day(2), "TEST", nil, nil, nil, 20.0, 0.0, 1.0).
+			AddRow(day(3), "TEST", nil, nil, nil, 10.0, 0.0, 2.0).
+			AddRow(day(4), "TEST", nil, nil, nil, 10.5, 0.0, 1.0)
+		expectQuery()
+
+		issues, err := eod.ValidateAsset(ctx, mock, "TEST", &eod.ValidationRules{MaxSplitFactor: 50, MaxDailyMove: .25})
+		Expect(err).To(BeNil())
+		Expect(checks(issues)).To(Equal([]string{eod.CheckUnexplainedMove}))
+		Expect(issues[0].EventDate).To(Equal(day(2)))
+		Expect(issues[0].Severity).To(Equal(eod.SeveritySoft))
+		Expect(eod.HasHardIssues(issues)).To(BeFalse())
+	})
+
+	It("should refuse to adjust assets that fail hard checks", func() {
+		rows.AddRow(day(1), "TEST", nil, nil, nil, 10.0, 0.0, 1000.0)
+		expectQuery()
+
+		_, err := eod.AdjustAsset(ctx, mock, "TEST", &eod.AdjustOptions{Validate: true})
+		Expect(err).To(MatchError(eod.ErrValidationFailed))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should write a csv report", func() {
+		issues := []*eod.ValidationIssue{
+			{CompositeFigi: "TEST", Ticker: "TEST", EventDate: day(2), Check: eod.CheckDuplicateDate, Severity: eod.SeverityHard, Message: "also quoted as TEST2"},
+		}
+		buf := &bytes.Buffer{}
+		Expect(eod.WriteValidationReport(buf, "csv", issues)).To(Succeed())
+		Expect(buf.String()).To(Equal("composite_figi,ticker,event_date,check,severity,message\nTEST,TEST,2021-01-02,duplicate date,hard,also quoted as TEST2\n"))
+	})
+})
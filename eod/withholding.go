@@ -0,0 +1,40 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// LoadWithholdingRate returns the withholding tax rate applied to an asset's
+// dividends in the net total return series. A rate for the figi takes
+// precedence over one for its country of domicile, which takes precedence
+// over the global rate; assets without any rate are not taxed.
+func LoadWithholdingRate(ctx context.Context, conn PgxIface, compositeFigi string) (float64, error) {
+	var rate float64
+	err := conn.QueryRow(ctx, `SELECT w.rate FROM withholding_tax_rates w LEFT JOIN asset_domicile d ON d.composite_figi = $1 WHERE w.composite_figi = $1 OR (w.composite_figi IS NULL AND w.country = d.country) OR (w.composite_figi IS NULL AND w.country IS NULL) ORDER BY (w.composite_figi IS NOT NULL) DESC, (w.country IS NOT NULL) DESC LIMIT 1`, compositeFigi).Scan(&rate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not load withholding tax rate")
+		return 0, err
+	}
+	return rate, nil
+}
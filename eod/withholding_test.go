@@ -0,0 +1,130 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var _ = Describe("net total return series", func() {
+	var (
+		ctx  context.Context
+		mock pgxmock.PgxConnIface
+		day1 time.Time
+		day2 time.Time
+	)
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mock, err = pgxmock.NewConn()
+		Expect(err).To(BeNil())
+
+		day1 = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		day2 = time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+	})
+
+	AfterEach(func() {
+		mock.Close(ctx)
+	})
+
+	expectEod := func() {
+		mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+) ORDER BY event_date DESC, (.+), ticker$").
+			WithArgs("TEST").
+			WillReturnRows(mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+				AddRow(day2, "TEST", "TEST", 20.0, 1.0, 1.0, nil, nil, nil, nil).
+				AddRow(day1, "TEST", "TEST", 21.0, 0.0, 1.0, nil, nil, nil, nil))
+	}
+
+	It("should reduce dividends by the withholding rate", func() {
+		mock.ExpectQuery("^SELECT w.rate FROM withholding_tax_rates w").
+			WithArgs("TEST").
+			WillReturnRows(mock.NewRows([]string{"rate"}).AddRow(0.3))
+		expectEod()
+
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{
+			Series: []eod.Series{eod.TotalReturnSeries, eod.NetTotalReturnSeries},
+		})
+		Expect(err).To(BeNil())
+		Expect(prices[1].CumDividendFactor).To(Equal(1.05))
+		Expect(prices[1].CumNetDividendFactor).To(BeNumerically("~", 1.035))
+		Expect(prices[1].AdjClose).To(BeNumerically("~", 20.0))
+		Expect(prices[1].NetAdjClose).To(BeNumerically("~", 21.0/1.035))
+		Expect(prices[0].NetAdjClose).To(Equal(20.0))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should only withhold tax from taxable dividend types", func() {
+		mock.ExpectQuery("^SELECT w.rate FROM withholding_tax_rates w").
+			WithArgs("TEST").
+			WillReturnRows(mock.NewRows([]string{"rate"}).AddRow(0.3))
+		mock.ExpectQuery("^SELECT (.+) FROM corporate_actions a").
+			WithArgs("TEST").
+			WillReturnRows(mock.NewRows([]string{"action_type", "ex_date", "amount", "ratio", "dividend_type", "distributed_figi", "close"}).
+				AddRow(eod.ActionDividend, day2, 1.0, nil, "regular", nil, nil).
+				AddRow(eod.ActionDividend, day2, 0.5, nil, "capital_gains", nil, nil).
+				AddRow(eod.ActionDividend, day2, 0.5, nil, "return_of_capital", nil, nil))
+		expectEod()
+
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{
+			ActionSource: eod.TableActions,
+			Series:       []eod.Series{eod.NetTotalReturnSeries},
+		})
+		Expect(err).To(BeNil())
+		// 2 gross of which only the regular 1 is taxed
+		Expect(prices[1].CumDividendFactor).To(BeNumerically("~", 1.1))
+		Expect(prices[1].CumNetDividendFactor).To(BeNumerically("~", 1+1.7/20))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should match the gross series when no rate is configured", func() {
+		mock.ExpectQuery("^SELECT w.rate FROM withholding_tax_rates w").
+			WithArgs("TEST").
+			WillReturnError(pgx.ErrNoRows)
+		expectEod()
+
+		prices, err := eod.AdjustAssetEodPriceWithOptions(ctx, mock, "TEST", &eod.AdjustOptions{
+			Series: []eod.Series{eod.NetTotalReturnSeries},
+		})
+		Expect(err).To(BeNil())
+		Expect(prices[1].NetAdjClose).To(Equal(prices[1].AdjClose))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should write the net series to net_adj_close", func() {
+		prices := []*eod.Eod{
+			{CompositeFigi: "TEST", EventDate: day1, NetAdjClose: 20.3, CumSplitFactor: 1, CumDividendFactor: 1.05},
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("^UPDATE eod SET net_adj_close=(.+) WHERE composite_figi=(.+) AND event_date=(.+)$").
+			WithArgs(20.3, "TEST", day1).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+		mock.ExpectQuery("^DELETE FROM eod_adjustment_factors").WithArgs("TEST").WillReturnRows(mock.NewRows([]string{"event_date", "split_factor", "dividend_factor"}))
+		mock.ExpectCopyFrom(`"eod_adjustment_factors"`, []string{"composite_figi", "event_date", "split_factor", "dividend_factor"}).WillReturnResult(1)
+		mock.ExpectExec("^INSERT INTO eod_adj_close_history").WithArgs("TEST", pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+		mock.ExpectCommit()
+
+		Expect(eod.SaveAdjCloseToDb(ctx, mock, prices, eod.NetTotalReturnSeries)).To(Succeed())
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+})
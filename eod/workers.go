@@ -0,0 +1,147 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrAdjustPanic = errors.New("panic while adjusting asset")
+)
+
+// AdjustSummary records the outcome of adjusting a set of assets. Diffs is
+// only populated for dry runs.
+type AdjustSummary struct {
+	Succeeded []string
+	Failed    map[string]error
+	Diffs     []*AdjCloseDiff
+}
+
+// AdjustAsset calculates the adjusted close for an asset and saves it to the
+// database. When opts.DryRun is set nothing is written and the difference
+// from the stored values is returned instead. opts may be nil to use the
+// defaults.
+func AdjustAsset(ctx context.Context, conn PgxIface, compositeFigi string, opts *AdjustOptions) (diff *AdjCloseDiff, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrAdjustPanic, r)
+		}
+	}()
+
+	if opts == nil {
+		opts = &AdjustOptions{}
+	}
+
+	if opts.Validate {
+		issues, err := ValidateAsset(ctx, conn, compositeFigi, opts.ValidationRules)
+		if err != nil {
+			return nil, err
+		}
+		if HasHardIssues(issues) {
+			for _, issue := range issues {
+				if issue.Severity == SeverityHard {
+					log.Warn().Str("CompositeFigi", compositeFigi).Time("EventDate", issue.EventDate).Str("Check", issue.Check).Str("Message", issue.Message).Msg("asset failed data quality check")
+				}
+			}
+			return nil, fmt.Errorf("%w: %s", ErrValidationFailed, compositeFigi)
+		}
+	}
+
+	prices, err := AdjustAssetEodPriceWithOptions(ctx, conn, compositeFigi, opts)
+	if err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not adjust asset prices")
+		return nil, err
+	}
+
+	RoundPrices(prices, opts.Rounding)
+
+	if opts.DryRun {
+		return DiffAssetAdjClose(ctx, conn, compositeFigi, prices, opts.Tolerance, opts.Series...)
+	}
+
+	if err := SaveAdjCloseRevision(ctx, conn, prices, opts.Revision, opts.Series...); err != nil {
+		log.Error().Err(err).Str("CompositeFigi", compositeFigi).Msg("could not save adjusted close to db")
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// AdjustAssets adjusts every asset in the list using up to workers concurrent
+// goroutines. conn must be safe for concurrent use (e.g. a pgxpool.Pool) when
+// workers is greater than 1. An error adjusting one asset is recorded in the
+// summary and does not stop the remaining assets from being adjusted. With
+// the sql engine assets are adjusted in sequential batches and workers is
+// ignored.
+func AdjustAssets(ctx context.Context, conn PgxIface, assets []string, workers int, opts *AdjustOptions) *AdjustSummary {
+	if opts != nil && opts.Engine == SQLEngine {
+		return adjustAssetsSQL(ctx, conn, assets, opts)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	summary := &AdjustSummary{
+		Succeeded: make([]string, 0, len(assets)),
+		Failed:    make(map[string]error),
+	}
+
+	jobs := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for ii := 0; ii < workers; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for compositeFigi := range jobs {
+				log.Info().Str("CompositeFigi", compositeFigi).Msg("adjusting close price for asset")
+				diff, err := AdjustAsset(ctx, conn, compositeFigi, opts)
+
+				mu.Lock()
+				if err != nil {
+					summary.Failed[compositeFigi] = err
+				} else {
+					summary.Succeeded = append(summary.Succeeded, compositeFigi)
+				}
+				if diff != nil {
+					summary.Diffs = append(summary.Diffs, diff)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, compositeFigi := range assets {
+		jobs <- compositeFigi
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Strings(summary.Succeeded)
+	sort.Slice(summary.Diffs, func(i, j int) bool {
+		return summary.Diffs[i].CompositeFigi < summary.Diffs[j].CompositeFigi
+	})
+
+	return summary
+}
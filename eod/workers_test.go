@@ -0,0 +1,97 @@
+// Copyright 2022-2023
+// SPDX-License-Identifier: Apache-2.0
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package eod_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgtype"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pashagolub/pgxmock"
+	"github.com/penny-vault/eod-maintenance/eod"
+)
+
+var null = pgtype.Float8{Status: pgtype.Null}
+
+var _ = Describe("adjust multiple assets", func() {
+	var (
+		ctx   context.Context
+		day1  time.Time
+		day2  time.Time
+		setup func(mock pgxmock.PgxPoolIface)
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		day1 = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		day2 = time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+
+		setup = func(mock pgxmock.PgxPoolIface) {
+			mock.MatchExpectationsInOrder(false)
+
+			for _, figi := range []string{"AAA", "BBB"} {
+				rows := mock.NewRows([]string{"event_date", "ticker", "composite_figi", "close", "dividend", "split_factor", "open", "high", "low", "volume"}).
+					AddRow(day2, figi, figi, 1.0, 0.0, 2.0, nil, nil, nil, nil).
+					AddRow(day1, figi, figi, 1.0, 0.0, 1.0, nil, nil, nil, nil)
+				mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+)").WithArgs(figi).WillReturnRows(rows)
+				mock.ExpectBegin()
+				mock.ExpectExec("^UPDATE eod SET adj_close=(.+)").WithArgs(1.0, null, null, null, null, figi, day2).WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+				mock.ExpectExec("^UPDATE eod SET adj_close=(.+)").WithArgs(.5, null, null, null, null, figi, day1).WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+				mock.ExpectQuery("^DELETE FROM eod_adjustment_factors").WithArgs(figi).WillReturnRows(mock.NewRows([]string{"event_date", "split_factor", "dividend_factor"}))
+				mock.ExpectCopyFrom(`"eod_adjustment_factors"`, []string{"composite_figi", "event_date", "split_factor", "dividend_factor"}).WillReturnResult(2)
+				mock.ExpectExec("^INSERT INTO eod_adj_close_history").WithArgs(figi, pgxmock.AnyArg(), pgxmock.AnyArg(), pgxmock.AnyArg()).WillReturnResult(pgxmock.NewResult("INSERT", 1))
+				mock.ExpectCommit()
+			}
+
+			mock.ExpectQuery("^SELECT (.+) FROM eod WHERE composite_figi = (.+)").WithArgs("BAD").WillReturnError(errors.New("query failed"))
+		}
+	})
+
+	It("should isolate failures and summarize results", func() {
+		mock, err := pgxmock.NewPool()
+		Expect(err).To(BeNil())
+		defer mock.Close()
+		setup(mock)
+
+		summary := eod.AdjustAssets(ctx, mock, []string{"AAA", "BAD", "BBB"}, 4, nil)
+		Expect(summary.Succeeded).To(Equal([]string{"AAA", "BBB"}))
+		Expect(summary.Failed).To(HaveLen(1))
+		Expect(summary.Failed).To(HaveKey("BAD"))
+		Expect(mock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("should match a sequential run", func() {
+		sequential, err := pgxmock.NewPool()
+		Expect(err).To(BeNil())
+		defer sequential.Close()
+		setup(sequential)
+
+		concurrent, err := pgxmock.NewPool()
+		Expect(err).To(BeNil())
+		defer concurrent.Close()
+		setup(concurrent)
+
+		expected := eod.AdjustAssets(ctx, sequential, []string{"AAA", "BAD", "BBB"}, 1, nil)
+		actual := eod.AdjustAssets(ctx, concurrent, []string{"AAA", "BAD", "BBB"}, 3, nil)
+
+		Expect(actual.Succeeded).To(Equal(expected.Succeeded))
+		Expect(actual.Failed).To(HaveLen(len(expected.Failed)))
+		Expect(sequential.ExpectationsWereMet()).To(Succeed())
+		Expect(concurrent.ExpectationsWereMet()).To(Succeed())
+	})
+})
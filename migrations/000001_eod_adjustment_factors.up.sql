@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS eod_adjustment_factors (
+    composite_figi   TEXT NOT NULL,
+    event_date       DATE NOT NULL,
+    split_factor     DOUBLE PRECISION NOT NULL DEFAULT 1.0,
+    dividend_factor  DOUBLE PRECISION NOT NULL DEFAULT 1.0,
+    PRIMARY KEY (composite_figi, event_date)
+);
+
+COMMENT ON TABLE eod_adjustment_factors IS 'CRSP cumulative adjustment factors; price / (split_factor * dividend_factor) = adjusted price';
+COMMENT ON COLUMN eod_adjustment_factors.split_factor IS 'product of all split factors with an ex-date after event_date';
+COMMENT ON COLUMN eod_adjustment_factors.dividend_factor IS 'product of (1 + dividend / close) for all dividends with an ex-date after event_date';
@@ -0,0 +1 @@
+ALTER TABLE eod DROP COLUMN IF EXISTS split_adj_close;
@@ -0,0 +1,3 @@
+ALTER TABLE eod ADD COLUMN IF NOT EXISTS split_adj_close DOUBLE PRECISION;
+
+COMMENT ON COLUMN eod.split_adj_close IS 'close adjusted for splits only; adj_close is adjusted for splits and dividends';
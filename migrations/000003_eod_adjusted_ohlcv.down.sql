@@ -0,0 +1,4 @@
+ALTER TABLE eod DROP COLUMN IF EXISTS adj_volume;
+ALTER TABLE eod DROP COLUMN IF EXISTS adj_low;
+ALTER TABLE eod DROP COLUMN IF EXISTS adj_high;
+ALTER TABLE eod DROP COLUMN IF EXISTS adj_open;
@@ -0,0 +1,9 @@
+ALTER TABLE eod ADD COLUMN IF NOT EXISTS adj_open DOUBLE PRECISION;
+ALTER TABLE eod ADD COLUMN IF NOT EXISTS adj_high DOUBLE PRECISION;
+ALTER TABLE eod ADD COLUMN IF NOT EXISTS adj_low DOUBLE PRECISION;
+ALTER TABLE eod ADD COLUMN IF NOT EXISTS adj_volume DOUBLE PRECISION;
+
+COMMENT ON COLUMN eod.adj_open IS 'open adjusted for splits and dividends';
+COMMENT ON COLUMN eod.adj_high IS 'high adjusted for splits and dividends';
+COMMENT ON COLUMN eod.adj_low IS 'low adjusted for splits and dividends';
+COMMENT ON COLUMN eod.adj_volume IS 'volume adjusted for splits only';
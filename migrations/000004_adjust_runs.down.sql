@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS eod_actions_updated_at ON eod;
+DROP FUNCTION IF EXISTS eod_actions_updated_at();
+DROP INDEX IF EXISTS eod_actions_updated_at_idx;
+ALTER TABLE eod DROP COLUMN IF EXISTS actions_updated_at;
+DROP TABLE IF EXISTS adjust_runs;
@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS adjust_runs (
+    run_id       BIGSERIAL PRIMARY KEY,
+    scope        TEXT NOT NULL,
+    started_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at  TIMESTAMPTZ,
+    status       TEXT NOT NULL DEFAULT 'running',
+    since        TIMESTAMPTZ,
+    num_assets   INTEGER NOT NULL DEFAULT 0,
+    num_failed   INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS adjust_runs_watermark_idx ON adjust_runs (scope, status, started_at);
+
+COMMENT ON TABLE adjust_runs IS 'history of adjust runs; started_at of the last successful recent or all run is the watermark for adjust --recent';
+
+-- track when corporate actions on an eod row were inserted or changed
+ALTER TABLE eod ADD COLUMN IF NOT EXISTS actions_updated_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS eod_actions_updated_at_idx ON eod (actions_updated_at) WHERE actions_updated_at IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION eod_actions_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        IF NEW.dividend <> 0.0 OR NEW.split_factor <> 1.0 THEN
+            NEW.actions_updated_at = now();
+        END IF;
+    ELSIF NEW.dividend IS DISTINCT FROM OLD.dividend OR NEW.split_factor IS DISTINCT FROM OLD.split_factor THEN
+        NEW.actions_updated_at = now();
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER eod_actions_updated_at
+    BEFORE INSERT OR UPDATE OF dividend, split_factor ON eod
+    FOR EACH ROW EXECUTE FUNCTION eod_actions_updated_at();
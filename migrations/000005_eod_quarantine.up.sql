@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS eod_quarantine (
+    composite_figi  TEXT NOT NULL,
+    event_date      DATE NOT NULL,
+    ticker          TEXT,
+    close           DOUBLE PRECISION,
+    reason          TEXT NOT NULL,
+    policy          TEXT NOT NULL,
+    detected_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (composite_figi, event_date, reason)
+);
+
+COMMENT ON TABLE eod_quarantine IS 'eod quotes that could not be adjusted normally and the policy that was applied to them';
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS split_inference_audit;
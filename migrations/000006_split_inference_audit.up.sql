@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS split_inference_audit (
+    audit_id              BIGSERIAL PRIMARY KEY,
+    composite_figi        TEXT NOT NULL,
+    event_date            DATE NOT NULL,
+    ticker                TEXT,
+    prev_close            DOUBLE PRECISION NOT NULL,
+    close                 DOUBLE PRECISION NOT NULL,
+    old_split_factor      DOUBLE PRECISION NOT NULL,
+    proposed_split_factor DOUBLE PRECISION NOT NULL,
+    confirmed             BOOLEAN NOT NULL DEFAULT false,
+    applied               BOOLEAN NOT NULL DEFAULT false,
+    created_at            TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS split_inference_audit_figi_idx ON split_inference_audit (composite_figi, event_date);
+
+-- each proposal is recorded once no matter how often the inference runs
+CREATE UNIQUE INDEX IF NOT EXISTS split_inference_audit_proposal_idx ON split_inference_audit (composite_figi, event_date, proposed_split_factor);
+
+COMMENT ON TABLE split_inference_audit IS 'splits inferred from unexplained price jumps; applied is true when the proposal was written to eod.split_factor';
+COMMENT ON COLUMN split_inference_audit.confirmed IS 'true when the price stayed at the new level after the jump; only confirmed proposals are applied';
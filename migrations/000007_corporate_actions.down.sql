@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS corporate_actions;
+DROP FUNCTION IF EXISTS corporate_actions_updated_at();
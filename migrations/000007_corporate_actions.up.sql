@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS corporate_actions (
+    action_id       BIGSERIAL PRIMARY KEY,
+    composite_figi  TEXT NOT NULL,
+    action_type     TEXT NOT NULL,
+    ex_date         DATE NOT NULL,
+    pay_date        DATE,
+    amount          DOUBLE PRECISION,
+    ratio           DOUBLE PRECISION,
+    source          TEXT,
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (action_type IN ('dividend', 'split')),
+    CHECK (action_type <> 'dividend' OR amount IS NOT NULL),
+    CHECK (action_type <> 'split' OR ratio IS NOT NULL)
+);
+
+COMMENT ON TABLE corporate_actions IS 'dividends (amount per share) and splits (ratio, e.g. 2 for a 2:1 split) used by adjust --actions table';
+
+-- an asset may have several actions of one type on an ex-date (e.g. a
+-- regular and a special dividend), so actions are only keyed by action_id
+CREATE INDEX IF NOT EXISTS corporate_actions_figi_idx ON corporate_actions (composite_figi, ex_date);
+
+CREATE OR REPLACE FUNCTION corporate_actions_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER corporate_actions_updated_at
+    BEFORE UPDATE ON corporate_actions
+    FOR EACH ROW EXECUTE FUNCTION corporate_actions_updated_at();
+
+CREATE INDEX IF NOT EXISTS corporate_actions_updated_at_idx ON corporate_actions (updated_at);
+
+-- backfill from the actions embedded in eod; quotes duplicated across ticker
+-- changes collapse onto one action
+INSERT INTO corporate_actions (composite_figi, action_type, ex_date, amount, source)
+    SELECT composite_figi, 'dividend', event_date, max(dividend), 'eod'
+    FROM eod
+    WHERE dividend <> 0.0
+    GROUP BY composite_figi, event_date;
+
+INSERT INTO corporate_actions (composite_figi, action_type, ex_date, ratio, source)
+    SELECT composite_figi, 'split', event_date, max(split_factor), 'eod'
+    FROM eod
+    WHERE split_factor <> 1.0
+    GROUP BY composite_figi, event_date;
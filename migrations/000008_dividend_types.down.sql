@@ -0,0 +1,2 @@
+ALTER TABLE corporate_actions DROP CONSTRAINT IF EXISTS corporate_actions_dividend_type_check;
+ALTER TABLE corporate_actions DROP COLUMN IF EXISTS dividend_type;
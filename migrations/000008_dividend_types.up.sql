@@ -0,0 +1,9 @@
+ALTER TABLE corporate_actions ADD COLUMN IF NOT EXISTS dividend_type TEXT;
+
+UPDATE corporate_actions SET dividend_type = 'regular' WHERE action_type = 'dividend' AND dividend_type IS NULL;
+
+ALTER TABLE corporate_actions ADD CONSTRAINT corporate_actions_dividend_type_check
+    CHECK ((action_type = 'dividend') = (dividend_type IS NOT NULL) AND
+           (dividend_type IS NULL OR dividend_type IN ('regular', 'special', 'capital_gains', 'return_of_capital')));
+
+COMMENT ON COLUMN corporate_actions.dividend_type IS 'regular, special, capital_gains or return_of_capital; NULL for non-dividend actions';
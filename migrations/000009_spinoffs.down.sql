@@ -0,0 +1,7 @@
+DELETE FROM corporate_actions WHERE action_type = 'spinoff';
+DROP INDEX IF EXISTS corporate_actions_distributed_figi_idx;
+ALTER TABLE corporate_actions DROP CONSTRAINT IF EXISTS corporate_actions_spinoff_check;
+ALTER TABLE corporate_actions DROP CONSTRAINT IF EXISTS corporate_actions_action_type_check;
+ALTER TABLE corporate_actions ADD CONSTRAINT corporate_actions_action_type_check
+    CHECK (action_type IN ('dividend', 'split'));
+ALTER TABLE corporate_actions DROP COLUMN IF EXISTS distributed_figi;
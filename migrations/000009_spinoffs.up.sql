@@ -0,0 +1,15 @@
+ALTER TABLE corporate_actions ADD COLUMN IF NOT EXISTS distributed_figi TEXT;
+
+ALTER TABLE corporate_actions DROP CONSTRAINT IF EXISTS corporate_actions_action_type_check;
+ALTER TABLE corporate_actions ADD CONSTRAINT corporate_actions_action_type_check
+    CHECK (action_type IN ('dividend', 'split', 'spinoff'));
+
+-- spin-offs need the distributed asset and the number of its shares per
+-- share held; amount is the distributed asset's price on the ex-date and
+-- falls back to its eod close when NULL
+ALTER TABLE corporate_actions ADD CONSTRAINT corporate_actions_spinoff_check
+    CHECK (action_type <> 'spinoff' OR (ratio IS NOT NULL AND distributed_figi IS NOT NULL));
+
+CREATE INDEX IF NOT EXISTS corporate_actions_distributed_figi_idx ON corporate_actions (distributed_figi) WHERE distributed_figi IS NOT NULL;
+
+COMMENT ON COLUMN corporate_actions.distributed_figi IS 'composite figi of the asset distributed by a spin-off';
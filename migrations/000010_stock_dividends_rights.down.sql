@@ -0,0 +1,6 @@
+DELETE FROM corporate_actions WHERE action_type IN ('stock_dividend', 'rights');
+ALTER TABLE corporate_actions DROP CONSTRAINT IF EXISTS corporate_actions_rights_check;
+ALTER TABLE corporate_actions DROP CONSTRAINT IF EXISTS corporate_actions_stock_dividend_check;
+ALTER TABLE corporate_actions DROP CONSTRAINT IF EXISTS corporate_actions_action_type_check;
+ALTER TABLE corporate_actions ADD CONSTRAINT corporate_actions_action_type_check
+    CHECK (action_type IN ('dividend', 'split', 'spinoff'));
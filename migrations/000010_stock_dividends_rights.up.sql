@@ -0,0 +1,10 @@
+ALTER TABLE corporate_actions DROP CONSTRAINT IF EXISTS corporate_actions_action_type_check;
+ALTER TABLE corporate_actions ADD CONSTRAINT corporate_actions_action_type_check
+    CHECK (action_type IN ('dividend', 'split', 'spinoff', 'stock_dividend', 'rights'));
+
+-- stock dividends and rights store new shares per share held in ratio;
+-- rights store the subscription price in amount
+ALTER TABLE corporate_actions ADD CONSTRAINT corporate_actions_stock_dividend_check
+    CHECK (action_type <> 'stock_dividend' OR ratio IS NOT NULL);
+ALTER TABLE corporate_actions ADD CONSTRAINT corporate_actions_rights_check
+    CHECK (action_type <> 'rights' OR (ratio IS NOT NULL AND amount IS NOT NULL));
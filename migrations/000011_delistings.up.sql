@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS delistings (
+    composite_figi    TEXT PRIMARY KEY,
+    delisting_date    DATE NOT NULL,
+    payment_date      DATE,
+    delisting_return  DOUBLE PRECISION,
+    final_payment     DOUBLE PRECISION,
+    delisting_code    TEXT,
+    CHECK (delisting_return IS NOT NULL OR final_payment IS NOT NULL)
+);
+
+COMMENT ON TABLE delistings IS 'delisting events; delisting_return is measured from the last traded close to the delisting proceeds (CRSP DLRET) and is derived from final_payment when NULL';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS withholding_tax_rates;
+DROP TABLE IF EXISTS asset_domicile;
+ALTER TABLE eod DROP COLUMN IF EXISTS net_adj_close;
@@ -0,0 +1,23 @@
+ALTER TABLE eod ADD COLUMN IF NOT EXISTS net_adj_close DOUBLE PRECISION;
+
+COMMENT ON COLUMN eod.net_adj_close IS 'close adjusted for splits and dividends net of withholding tax; written by adjust --series net';
+
+CREATE TABLE IF NOT EXISTS asset_domicile (
+    composite_figi  TEXT PRIMARY KEY,
+    country         TEXT NOT NULL
+);
+
+COMMENT ON TABLE asset_domicile IS 'country of domicile (ISO 3166 alpha-2) used to look up withholding tax rates';
+
+-- a row with only composite_figi set applies to that asset, one with only
+-- country set to assets domiciled there and one with neither to every asset
+CREATE TABLE IF NOT EXISTS withholding_tax_rates (
+    composite_figi  TEXT,
+    country         TEXT,
+    rate            DOUBLE PRECISION NOT NULL CHECK (rate >= 0 AND rate <= 1),
+    CHECK (composite_figi IS NULL OR country IS NULL)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS withholding_tax_rates_key_idx ON withholding_tax_rates (COALESCE(composite_figi, ''), COALESCE(country, ''));
+
+COMMENT ON TABLE withholding_tax_rates IS 'dividend withholding tax rates for the net total return series; the most specific matching rate applies';
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS eod_adj_close_history;
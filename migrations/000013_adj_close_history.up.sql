@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS eod_adj_close_history (
+    revision_id     BIGSERIAL PRIMARY KEY,
+    composite_figi  TEXT NOT NULL,
+    event_date      DATE NOT NULL,
+    adj_close       DOUBLE PRECISION,
+    run_id          BIGINT REFERENCES adjust_runs (run_id),
+    reason          TEXT,
+    recorded_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS eod_adj_close_history_asset_idx ON eod_adj_close_history (composite_figi, event_date, recorded_at);
+
+COMMENT ON TABLE eod_adj_close_history IS 'every revision of eod.adj_close; the latest row recorded at or before a moment is the value stored then';
+
+-- seed the history with the values stored today
+INSERT INTO eod_adj_close_history (composite_figi, event_date, adj_close, reason)
+    SELECT composite_figi, event_date, adj_close, 'baseline' FROM eod WHERE adj_close IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS adjust_run_factors;
+DROP TABLE IF EXISTS adjust_run_changes;
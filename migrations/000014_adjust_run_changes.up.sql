@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS adjust_run_changes (
+    run_id                    BIGINT NOT NULL REFERENCES adjust_runs (run_id) ON DELETE CASCADE,
+    composite_figi            TEXT NOT NULL,
+    event_date                DATE NOT NULL,
+    previous_adj_close        DOUBLE PRECISION,
+    adj_close                 DOUBLE PRECISION,
+    previous_adj_open         DOUBLE PRECISION,
+    adj_open                  DOUBLE PRECISION,
+    previous_adj_high         DOUBLE PRECISION,
+    adj_high                  DOUBLE PRECISION,
+    previous_adj_low          DOUBLE PRECISION,
+    adj_low                   DOUBLE PRECISION,
+    previous_adj_volume       DOUBLE PRECISION,
+    adj_volume                DOUBLE PRECISION,
+    previous_split_adj_close  DOUBLE PRECISION,
+    split_adj_close           DOUBLE PRECISION,
+    previous_net_adj_close    DOUBLE PRECISION,
+    net_adj_close             DOUBLE PRECISION,
+    PRIMARY KEY (run_id, composite_figi, event_date)
+);
+
+CREATE TABLE IF NOT EXISTS adjust_run_factors (
+    run_id                    BIGINT NOT NULL REFERENCES adjust_runs (run_id) ON DELETE CASCADE,
+    composite_figi            TEXT NOT NULL,
+    event_date                DATE NOT NULL,
+    previous_split_factor     DOUBLE PRECISION,
+    split_factor              DOUBLE PRECISION,
+    previous_dividend_factor  DOUBLE PRECISION,
+    dividend_factor           DOUBLE PRECISION,
+    PRIMARY KEY (run_id, composite_figi, event_date)
+);
+
+COMMENT ON TABLE adjust_run_changes IS 'adjusted prices changed by each adjust run, before and after; read by adjust rollback';
+COMMENT ON TABLE adjust_run_factors IS 'eod_adjustment_factors rows added, changed or removed by each adjust run, before and after; read by adjust rollback';
+COMMENT ON COLUMN adjust_run_factors.previous_split_factor IS 'eod_adjustment_factors.split_factor before the run; NULL if the row was added by the run';
+COMMENT ON COLUMN adjust_run_factors.split_factor IS 'eod_adjustment_factors.split_factor written by the run; NULL if the run removed the row';
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS corporate_actions_moved ON corporate_actions;
+DROP TRIGGER IF EXISTS corporate_actions_deleted ON corporate_actions;
+DROP FUNCTION IF EXISTS corporate_actions_deleted();
+DROP TABLE IF EXISTS corporate_actions_deleted;
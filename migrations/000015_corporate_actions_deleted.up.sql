@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS corporate_actions_deleted (
+    action_id       BIGINT NOT NULL,
+    composite_figi  TEXT NOT NULL,
+    action_type     TEXT NOT NULL,
+    ex_date         DATE NOT NULL,
+    deleted_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS corporate_actions_deleted_at_idx ON corporate_actions_deleted (deleted_at);
+
+COMMENT ON TABLE corporate_actions_deleted IS 'tombstones of corporate actions deleted or moved to another asset so adjust --recent recalculates the asset they were removed from';
+
+CREATE OR REPLACE FUNCTION corporate_actions_deleted() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO corporate_actions_deleted (action_id, composite_figi, action_type, ex_date)
+        VALUES (OLD.action_id, OLD.composite_figi, OLD.action_type, OLD.ex_date);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER corporate_actions_deleted
+    AFTER DELETE ON corporate_actions
+    FOR EACH ROW EXECUTE FUNCTION corporate_actions_deleted();
+
+CREATE TRIGGER corporate_actions_moved
+    AFTER UPDATE OF composite_figi ON corporate_actions
+    FOR EACH ROW WHEN (OLD.composite_figi IS DISTINCT FROM NEW.composite_figi)
+    EXECUTE FUNCTION corporate_actions_deleted();